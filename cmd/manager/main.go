@@ -4,15 +4,19 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"runtime"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 
 	"github.com/eformat/microsegmentation-operator/pkg/apis"
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
 	"github.com/eformat/microsegmentation-operator/pkg/controller"
+	"github.com/eformat/microsegmentation-operator/pkg/reachability"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
 	"github.com/operator-framework/operator-sdk/pkg/leader"
@@ -23,6 +27,7 @@ import (
 	"github.com/spf13/pflag"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
@@ -34,6 +39,9 @@ var (
 	metricsHost               = "0.0.0.0"
 	metricsPort         int32 = 8383
 	operatorMetricsPort int32 = 8686
+	connectivityHost          = "127.0.0.1"
+	connectivityPort    int32 = 8787
+	connectivityTTL           = 30 * time.Second
 )
 var log = logf.Log.WithName("cmd")
 
@@ -112,6 +120,8 @@ func main() {
 		os.Exit(1)
 	}
 
+	go serveConnectivityMatrix(mgr.GetClient())
+
 	if err = serveCRMetrics(cfg); err != nil {
 		log.Info("Could not generate and serve custom resource metrics", "error", err.Error())
 	}
@@ -172,3 +182,20 @@ func serveCRMetrics(cfg *rest.Config) error {
 	}
 	return nil
 }
+
+// serveConnectivityMatrix serves the connectivity matrix of the cluster, read from the manager cache,
+// on "http://connectivityHost:connectivityPort/connectivity". The matrix is computed from NetworkPolicies,
+// it is not served when policies are rendered by another backend.
+func serveConnectivityMatrix(c client.Reader) {
+	b, err := backend.FromEnv()
+	if err != nil || b.Name() != "kubernetes" {
+		log.Info("Connectivity matrix not served, only NetworkPolicies are evaluated", "backend", os.Getenv(backend.BackendEnv))
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(reachability.MatrixPath, reachability.MatrixHandler(c, connectivityTTL))
+	err = http.ListenAndServe(fmt.Sprintf("%s:%d", connectivityHost, connectivityPort), mux)
+	if err != nil {
+		log.Error(err, "Connectivity matrix server exited")
+	}
+}
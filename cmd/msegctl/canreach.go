@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/reachability"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// errDenied is returned by can-reach when the connection is denied
+var errDenied = exitCode(3)
+
+// runCanReach tells whether a source pod can reach a destination pod or service on a port, and which policies
+// allow or deny it
+func runCanReach(args []string) error {
+	flags := flag.NewFlagSet("can-reach", flag.ExitOnError)
+	snapshotFlags := addSnapshotFlags(flags)
+	from := flags.String("from", "", "source pod, namespace/name")
+	to := flags.String("to", "", "destination pod, namespace/name")
+	toService := flags.String("to-service", "", "destination service, namespace/name; every pod backing the service port is checked")
+	port := flags.Int("port", 0, "destination port, the service port with -to-service")
+	protocol := flags.String("protocol", "TCP", "protocol: TCP, UDP or SCTP")
+	flags.Parse(args)
+
+	if *from == "" || (*to == "") == (*toService == "") || *port <= 0 {
+		flags.Usage()
+		return fmt.Errorf("-from, -port and one of -to or -to-service are required")
+	}
+	snapshot, err := snapshotFlags.load(time.Now())
+	if err != nil {
+		return err
+	}
+
+	namespace, name, err := splitName(*from)
+	if err != nil {
+		return err
+	}
+	source := snapshot.FindPod(namespace, name)
+	if source == nil {
+		return fmt.Errorf("pod %s not found", *from)
+	}
+
+	targets := []reachability.Target{}
+	if *to != "" {
+		namespace, name, err = splitName(*to)
+		if err != nil {
+			return err
+		}
+		destination := snapshot.FindPod(namespace, name)
+		if destination == nil {
+			return fmt.Errorf("pod %s not found", *to)
+		}
+		targets = append(targets, reachability.Target{Pod: destination, Port: int32(*port), Protocol: corev1.Protocol(strings.ToUpper(*protocol))})
+	} else {
+		namespace, name, err = splitName(*toService)
+		if err != nil {
+			return err
+		}
+		service := snapshot.FindService(namespace, name)
+		if service == nil {
+			return fmt.Errorf("service %s not found", *toService)
+		}
+		targets = snapshot.ServiceTargets(service, int32(*port))
+		if len(targets) == 0 {
+			return fmt.Errorf("no pod backs port %d of service %s", *port, *toService)
+		}
+	}
+
+	denied := false
+	for _, target := range targets {
+		verdict := snapshot.Check(source, target.Pod, target.Port, target.Protocol)
+		fmt.Println(verdict.Explain())
+		denied = denied || !verdict.Allowed
+	}
+	if denied {
+		return errDenied
+	}
+	return nil
+}
+
+func splitName(value string) (string, string, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q must be namespace/name", value)
+	}
+	return parts[0], parts[1], nil
+}
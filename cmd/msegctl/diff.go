@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	serializer "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// errDifferences is returned by diff when the rendered policies differ from the current ones
+var errDifferences = exitCode(3)
+
+// lines of context around the changes of a unified diff
+const diffContext = 3
+
+// policyChange is a generated policy to create, update or delete
+type policyChange struct {
+	key     string
+	current *unstructured.Unstructured
+	desired *unstructured.Unstructured
+	// stale is set for a policy whose owner is gone, that garbage collection removes
+	stale bool
+}
+
+// runDiff prints a unified diff between the policies the controllers generate for Namespace and Service manifests
+// and the generated policies in the cluster or in a snapshot
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	files := fileList{}
+	flags.Var(&files, "f", "Namespace and Service manifest file to render, - for stdin (repeatable)")
+	currentFiles := fileList{}
+	flags.Var(&currentFiles, "current", "manifest file with the current policies, instead of the cluster (repeatable)")
+	backendName := flags.String("backend", os.Getenv(backend.BackendEnv), "policy backend to render with: kubernetes, calico or cilium")
+	at := flags.String("at", "", "RFC3339 time to evaluate schedules and temporary access at, now when omitted")
+	flags.Parse(args)
+
+	policyBackend, err := backend.New(*backendName)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if *at != "" {
+		now, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return err
+		}
+	}
+	m, err := readManifests(files, os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]*unstructured.Unstructured{}
+	for _, networkPolicy := range renderNetworkPolicies(m, now) {
+		rendered, err := backend.RenderPolicy(policyBackend, networkPolicy)
+		if err != nil {
+			return err
+		}
+		obj, err := normalizePolicy(rendered)
+		if err != nil {
+			return err
+		}
+		desired[getPolicyKey(obj)] = obj
+	}
+
+	owners := map[string]bool{}
+	namespaces := map[string]bool{}
+	for _, namespace := range m.namespaces {
+		owners[getOwnerKey("Namespace", namespace.GetName(), namespace.GetName())] = true
+		namespaces[namespace.GetName()] = true
+	}
+	for _, service := range m.services {
+		// the policies of ExternalName services depend on DNS answers and those of selector-less services on
+		// endpoints, they are not rendered
+		if service.Spec.Type == corev1.ServiceTypeExternalName || len(service.Spec.Selector) == 0 {
+			continue
+		}
+		owners[getOwnerKey("Service", service.GetNamespace(), service.GetName())] = true
+		namespaces[service.GetNamespace()] = true
+	}
+
+	var current []*unstructured.Unstructured
+	var c client.Client
+	if len(currentFiles) > 0 {
+		current, err = readCurrentPolicies(currentFiles, policyBackend)
+	} else {
+		c, err = newClient()
+		if err != nil {
+			return err
+		}
+		current, err = listCurrentPolicies(c, policyBackend, namespaces)
+	}
+	if err != nil {
+		return err
+	}
+
+	changes := map[string]*policyChange{}
+	for key, obj := range desired {
+		changes[key] = &policyChange{key: key, desired: obj}
+	}
+	for _, obj := range current {
+		if !namespaces[obj.GetNamespace()] {
+			continue
+		}
+		owner := metav1.GetControllerOf(obj)
+		if owner == nil || (owner.Kind != "Namespace" && owner.Kind != "Service") {
+			continue
+		}
+		key := getPolicyKey(obj)
+		stale := false
+		if !owners[getOwnerKey(owner.Kind, obj.GetNamespace(), owner.Name)] {
+			// policies of objects not rendered are only reported when their owner is gone
+			if c == nil {
+				continue
+			}
+			stale, err = isOwnerGone(c, obj.GetNamespace(), owner)
+			if err != nil {
+				return err
+			}
+			if !stale {
+				continue
+			}
+		}
+		normalized, err := normalizePolicy(obj)
+		if err != nil {
+			return err
+		}
+		if changes[key] == nil {
+			changes[key] = &policyChange{key: key}
+		}
+		changes[key].current = normalized
+		changes[key].stale = stale
+	}
+
+	keys := []string{}
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	created, updated, deleted := 0, 0, 0
+	for _, key := range keys {
+		change := changes[key]
+		different, err := writePolicyDiff(os.Stdout, change)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !different:
+		case change.current == nil:
+			created++
+		case change.desired == nil:
+			deleted++
+		default:
+			updated++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d to create, %d to update, %d to delete\n", created, updated, deleted)
+	if created+updated+deleted > 0 {
+		return errDifferences
+	}
+	return nil
+}
+
+// readCurrentPolicies returns the objects of the kind rendered by policyBackend in files
+func readCurrentPolicies(files []string, policyBackend backend.Backend) ([]*unstructured.Unstructured, error) {
+	m, err := readManifests(files, os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	gvk := policyBackend.GroupVersionKind()
+	current := []*unstructured.Unstructured{}
+	for _, obj := range m.objects {
+		if obj.GetKind() != gvk.Kind || obj.GroupVersionKind().Group != gvk.Group {
+			continue
+		}
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace("default")
+		}
+		current = append(current, obj)
+	}
+	return current, nil
+}
+
+// listCurrentPolicies returns the objects of the kind rendered by policyBackend in namespaces
+func listCurrentPolicies(c client.Client, policyBackend backend.Backend, namespaces map[string]bool) ([]*unstructured.Unstructured, error) {
+	gvk := policyBackend.GroupVersionKind()
+	current := []*unstructured.Unstructured{}
+	for namespace := range namespaces {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := c.List(context.TODO(), client.InNamespace(namespace), list)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			current = append(current, &list.Items[i])
+		}
+	}
+	return current, nil
+}
+
+// isOwnerGone reports whether the Namespace or Service owner of a policy in namespace no longer exists
+func isOwnerGone(c client.Client, namespace string, owner *metav1.OwnerReference) (bool, error) {
+	var obj runtime.Object
+	key := types.NamespacedName{Name: owner.Name}
+	switch owner.Kind {
+	case "Namespace":
+		obj = &corev1.Namespace{}
+	default:
+		obj = &corev1.Service{}
+		key.Namespace = namespace
+	}
+	err := c.Get(context.TODO(), key, obj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false, err
+	}
+	return accessor.GetUID() != owner.UID, nil
+}
+
+// normalizePolicy keeps the fields the controllers set on a generated policy, NetworkPolicies being defaulted the
+// way the API server does so that rendered and stored policies compare equal
+func normalizePolicy(obj runtime.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	if u.GetKind() == "NetworkPolicy" && u.GroupVersionKind().Group == networking.GroupName {
+		networkPolicy := &networking.NetworkPolicy{}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(content, networkPolicy)
+		if err != nil {
+			return nil, err
+		}
+		defaultNetworkPolicy(networkPolicy)
+		content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(networkPolicy)
+		if err != nil {
+			return nil, err
+		}
+		u = &unstructured.Unstructured{Object: content}
+	}
+
+	normalized := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	normalized.SetAPIVersion(u.GetAPIVersion())
+	normalized.SetKind(u.GetKind())
+	normalized.SetName(u.GetName())
+	normalized.SetNamespace(u.GetNamespace())
+	normalized.SetLabels(u.GetLabels())
+	annotations := u.GetAnnotations()
+	delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+	delete(annotations, backend.PreviousSpecAnnotation)
+	if len(annotations) > 0 {
+		normalized.SetAnnotations(annotations)
+	}
+	if spec, ok := u.Object["spec"]; ok {
+		normalized.Object["spec"] = spec
+	}
+	return normalized, nil
+}
+
+// defaultNetworkPolicy sets the protocols and policy types the API server defaults
+func defaultNetworkPolicy(networkPolicy *networking.NetworkPolicy) {
+	defaultPorts := func(ports []networking.NetworkPolicyPort) {
+		for i := range ports {
+			if ports[i].Protocol == nil || *ports[i].Protocol == "" {
+				protocol := corev1.ProtocolTCP
+				ports[i].Protocol = &protocol
+			}
+		}
+	}
+	for i := range networkPolicy.Spec.Ingress {
+		defaultPorts(networkPolicy.Spec.Ingress[i].Ports)
+	}
+	for i := range networkPolicy.Spec.Egress {
+		defaultPorts(networkPolicy.Spec.Egress[i].Ports)
+	}
+	if len(networkPolicy.Spec.PolicyTypes) == 0 {
+		networkPolicy.Spec.PolicyTypes = []networking.PolicyType{networking.PolicyTypeIngress}
+		if len(networkPolicy.Spec.Egress) > 0 {
+			networkPolicy.Spec.PolicyTypes = append(networkPolicy.Spec.PolicyTypes, networking.PolicyTypeEgress)
+		}
+	}
+}
+
+func getPolicyKey(obj *unstructured.Unstructured) string {
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+func getOwnerKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// writePolicyDiff prints the unified diff of change, it reports whether current and desired differ
+func writePolicyDiff(w io.Writer, change *policyChange) (bool, error) {
+	current, err := toYAML(change.current)
+	if err != nil {
+		return false, err
+	}
+	desired, err := toYAML(change.desired)
+	if err != nil {
+		return false, err
+	}
+	if current == desired {
+		return false, nil
+	}
+	from, to := "current/"+change.key, "rendered/"+change.key
+	if change.current == nil {
+		from = "/dev/null"
+	}
+	if change.desired == nil {
+		to = "/dev/null"
+		if change.stale {
+			to += " (owner deleted, removed by garbage collection)"
+		}
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", from, to)
+	_, err = io.WriteString(w, unifiedDiff(splitLines(current), splitLines(desired)))
+	return true, err
+}
+
+func toYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	buffer := &bytes.Buffer{}
+	err := serializer.NewYAMLSerializer(serializer.DefaultMetaFactory, nil, nil).Encode(obj, buffer)
+	return buffer.String(), err
+}
+
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLine is a line of a diff, kind being ' ', '-' or '+'
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// unifiedDiff returns the hunks turning a into b, computed from their longest common subsequence
+func unifiedDiff(a []string, b []string) string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	lines := []diffLine{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+
+	out := &strings.Builder{}
+	// aLine and bLine are the line numbers, starting at 1, of lines[k] in a and b
+	aLine, bLine := 1, 1
+	for k := 0; k < len(lines); {
+		if lines[k].kind == ' ' {
+			aLine++
+			bLine++
+			k++
+			continue
+		}
+		// a hunk starts diffContext lines before the change and ends when diffContext*2 unchanged lines follow
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+			unchanged := end
+			for unchanged < len(lines) && lines[unchanged].kind == ' ' {
+				unchanged++
+			}
+			if unchanged == len(lines) || unchanged-end > diffContext*2 {
+				break
+			}
+			end = unchanged
+		}
+		last := end + diffContext
+		if last > len(lines) {
+			last = len(lines)
+		}
+		hunkA, hunkB := aLine-(k-start), bLine-(k-start)
+		countA, countB := 0, 0
+		for _, line := range lines[start:last] {
+			if line.kind != '+' {
+				countA++
+			}
+			if line.kind != '-' {
+				countB++
+			}
+		}
+		if countA == 0 {
+			hunkA--
+		}
+		if countB == 0 {
+			hunkB--
+		}
+		fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", hunkA, countA, hunkB, countB)
+		for _, line := range lines[start:last] {
+			out.WriteByte(line.kind)
+			out.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				out.WriteString("\n")
+			}
+		}
+		for _, line := range lines[k:last] {
+			if line.kind != '+' {
+				aLine++
+			}
+			if line.kind != '-' {
+				bLine++
+			}
+		}
+		k = last
+	}
+	return out.String()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines 1 to n, replaced by the values of replace for their number
+func numberedLines(n int, replace map[int]string) []string {
+	lines := []string{}
+	for i := 1; i <= n; i++ {
+		line, ok := replace[i]
+		if !ok {
+			line = strconv.Itoa(i)
+		}
+		lines = append(lines, line+"\n")
+	}
+	return lines
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		want []string
+	}{
+		{
+			name: "identical",
+			a:    numberedLines(3, nil),
+			b:    numberedLines(3, nil),
+			want: []string{},
+		},
+		{
+			name: "empty to new",
+			a:    []string{},
+			b:    []string{"x\n", "y\n"},
+			want: []string{"@@ -0,0 +1,2 @@", "+x", "+y"},
+		},
+		{
+			name: "deleted",
+			a:    []string{"x\n", "y\n"},
+			b:    []string{},
+			want: []string{"@@ -1,2 +0,0 @@", "-x", "-y"},
+		},
+		{
+			name: "line deleted",
+			a:    numberedLines(10, nil),
+			b:    append(numberedLines(4, nil), numberedLines(10, nil)[5:]...),
+			want: []string{"@@ -2,7 +2,6 @@", " 2", " 3", " 4", "-5", " 6", " 7", " 8"},
+		},
+		{
+			name: "line appended",
+			a:    numberedLines(5, nil),
+			b:    append(numberedLines(5, nil), "six\n"),
+			want: []string{"@@ -3,3 +3,4 @@", " 3", " 4", " 5", "+six"},
+		},
+		{
+			name: "hunks merged",
+			a:    numberedLines(12, nil),
+			b:    numberedLines(12, map[int]string{2: "two", 8: "eight"}),
+			want: []string{"@@ -1,11 +1,11 @@", " 1", "-2", "+two", " 3", " 4", " 5", " 6", " 7", "-8", "+eight", " 9", " 10", " 11"},
+		},
+		{
+			name: "hunks apart",
+			a:    numberedLines(16, nil),
+			b:    numberedLines(16, map[int]string{2: "two", 12: "twelve"}),
+			want: []string{
+				"@@ -1,5 +1,5 @@", " 1", "-2", "+two", " 3", " 4", " 5",
+				"@@ -9,7 +9,7 @@", " 9", " 10", " 11", "-12", "+twelve", " 13", " 14", " 15",
+			},
+		},
+	}
+	for _, test := range tests {
+		want := strings.Join(test.want, "\n")
+		if len(test.want) > 0 {
+			want += "\n"
+		}
+		if got := unifiedDiff(test.a, test.b); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, want)
+		}
+	}
+}
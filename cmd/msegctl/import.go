@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/controller/namespace"
+	"github.com/eformat/microsegmentation-operator/pkg/controller/service"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// errNotImported is returned by import when some policies cannot be expressed with annotations
+var errNotImported = exitCode(3)
+
+// kinds owning the policies generated by the operator controllers, never imported
+var generatedPolicyOwners = map[string]bool{
+	"Namespace":   true,
+	"Service":     true,
+	"Pod":         true,
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"CronJob":     true,
+	"Ingress":     true,
+	"Route":       true,
+}
+
+// runImport converts hand-written NetworkPolicies into the Namespace and Service annotations generating the same
+// rules, and reports the policies the annotations cannot express
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	snapshotFlags := &snapshotFlags{render: new(bool)}
+	flags.Var(&snapshotFlags.files, "f", "manifest file with Namespaces, Services and NetworkPolicies, - for stdin (repeatable)")
+	snapshotFlags.live = flags.Bool("live", false, "read the policies from the cluster of the current kubeconfig instead of manifests")
+	flags.Parse(args)
+
+	snapshot, err := snapshotFlags.load(time.Now())
+	if err != nil {
+		return err
+	}
+
+	// the annotated copies, keyed by namespace then namespace/name
+	namespaces := map[string]*corev1.Namespace{}
+	for i := range snapshot.Namespaces {
+		namespaces[snapshot.Namespaces[i].GetName()] = snapshot.Namespaces[i].DeepCopy()
+	}
+	services := map[string]*corev1.Service{}
+	for i := range snapshot.Services {
+		services[snapshot.Services[i].GetNamespace()+"/"+snapshot.Services[i].GetName()] = snapshot.Services[i].DeepCopy()
+	}
+
+	notImported := 0
+	for i := range snapshot.NetworkPolicies {
+		networkPolicy := &snapshot.NetworkPolicies[i]
+		if owner := metav1.GetControllerOf(networkPolicy); owner != nil && generatedPolicyOwners[owner.Kind] {
+			continue
+		}
+		name := networkPolicy.GetNamespace() + "/" + networkPolicy.GetName()
+		target, patterns, err := importNetworkPolicy(networkPolicy, namespaces, services)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: not imported, %s\n", name, err.Error())
+			notImported++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s, imported into %s\n", name, strings.Join(patterns, ", "), target)
+	}
+
+	objects := []runtime.Object{}
+	for i := range snapshot.Namespaces {
+		if patch := getAnnotationPatch("Namespace", &snapshot.Namespaces[i], namespaces[snapshot.Namespaces[i].GetName()]); patch != nil {
+			objects = append(objects, patch)
+		}
+	}
+	for i := range snapshot.Services {
+		key := snapshot.Services[i].GetNamespace() + "/" + snapshot.Services[i].GetName()
+		if patch := getAnnotationPatch("Service", &snapshot.Services[i], services[key]); patch != nil {
+			objects = append(objects, patch)
+		}
+	}
+	err = writeManifests(os.Stdout, objects)
+	if err != nil {
+		return err
+	}
+	if notImported > 0 {
+		return errNotImported
+	}
+	return nil
+}
+
+// importNetworkPolicy imports networkPolicy into its namespace when it applies to all its pods, or into the service
+// whose pods it selects. It returns what it was imported into and the patterns recognised.
+func importNetworkPolicy(networkPolicy *networking.NetworkPolicy, namespaces map[string]*corev1.Namespace, services map[string]*corev1.Service) (string, []string, error) {
+	selector := networkPolicy.Spec.PodSelector
+	if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		ns, ok := namespaces[networkPolicy.GetNamespace()]
+		if !ok {
+			return "", nil, fmt.Errorf("namespace %s not found", networkPolicy.GetNamespace())
+		}
+		patterns, err := namespace.ImportNetworkPolicy(ns, networkPolicy)
+		return "Namespace " + ns.GetName(), patterns, err
+	}
+
+	keys := []string{}
+	for key, svc := range services {
+		if svc.GetNamespace() == networkPolicy.GetNamespace() && len(selector.MatchExpressions) == 0 && reflect.DeepEqual(svc.Spec.Selector, selector.MatchLabels) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", nil, fmt.Errorf("selects the pods of no service")
+	}
+	sort.Strings(keys)
+	errs := []string{}
+	for _, key := range keys {
+		patterns, err := service.ImportNetworkPolicy(services[key], networkPolicy)
+		if err == nil {
+			return "Service " + key, patterns, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// getAnnotationPatch returns an object of kind setting the annotations added to original, nil when none was
+func getAnnotationPatch(kind string, original metav1.Object, annotated metav1.Object) *unstructured.Unstructured {
+	if reflect.DeepEqual(original.GetAnnotations(), annotated.GetAnnotations()) {
+		return nil
+	}
+	annotations := map[string]string{}
+	for key, value := range annotated.GetAnnotations() {
+		if current, ok := original.GetAnnotations()[key]; !ok || current != value {
+			annotations[key] = value
+		}
+	}
+	patch := &unstructured.Unstructured{}
+	patch.SetAPIVersion("v1")
+	patch.SetKind(kind)
+	patch.SetName(annotated.GetName())
+	patch.SetNamespace(annotated.GetNamespace())
+	patch.SetAnnotations(annotations)
+	return patch
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/learning"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// runLearn proposes Namespace and Service annotations from observed flows
+func runLearn(args []string) error {
+	flags := flag.NewFlagSet("learn", flag.ExitOnError)
+	snapshotFlags := &snapshotFlags{render: new(bool)}
+	flags.Var(&snapshotFlags.files, "f", "manifest file with the Namespaces, Pods and Services the flows are between (repeatable)")
+	snapshotFlags.live = flags.Bool("live", false, "read the Namespaces, Pods and Services from the cluster of the current kubeconfig instead of manifests")
+	flowFiles := fileList{}
+	flags.Var(&flowFiles, "flows", "file of flow records, - for stdin (repeatable)")
+	format := flags.String("format", "conntrack", "format of the flow records: "+strings.Join(learning.Formats(), ", "))
+	egress := flags.Bool("egress", false, "also propose outbound namespace labels, restricting egress to the namespaces observed")
+	output := flags.String("o", "report", "output: report, or yaml for the annotations to apply")
+	enforce := flags.Bool("enforce", false, "with -o yaml, set the annotations the operator enforces instead of drafts")
+	flags.Parse(args)
+
+	if len(flowFiles) == 0 || (len(snapshotFlags.files) == 0 && !*snapshotFlags.live) {
+		flags.Usage()
+		return fmt.Errorf("-flows and one of -f or -live are required")
+	}
+	if *output != "report" && *output != "yaml" {
+		return fmt.Errorf("unknown output %s, expected report or yaml", *output)
+	}
+	snapshot, err := snapshotFlags.load(time.Now())
+	if err != nil {
+		return err
+	}
+
+	flows := []learning.Flow{}
+	for _, file := range flowFiles {
+		var reader io.Reader = os.Stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			reader = f
+		}
+		source, err := learning.NewSource(*format, reader)
+		if err != nil {
+			return err
+		}
+		read, err := source.Flows()
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err.Error())
+		}
+		flows = append(flows, read...)
+	}
+
+	report := learning.Learn(snapshot, flows, *egress)
+	if *output == "report" {
+		writeLearningReport(os.Stdout, report, len(flows))
+		return nil
+	}
+	for _, note := range report.Notes {
+		fmt.Fprintln(os.Stderr, note)
+	}
+
+	objects := []runtime.Object{}
+	for _, suggestion := range report.Suggestions {
+		original := metav1.Object(&metav1.ObjectMeta{Name: suggestion.Name, Namespace: suggestion.Namespace})
+		switch suggestion.Kind {
+		case "Namespace":
+			for i := range snapshot.Namespaces {
+				if snapshot.Namespaces[i].GetName() == suggestion.Name {
+					original = &snapshot.Namespaces[i]
+				}
+			}
+		case "Service":
+			if service := snapshot.FindService(suggestion.Namespace, suggestion.Name); service != nil {
+				original = service
+			}
+		}
+		annotated := &metav1.ObjectMeta{Name: suggestion.Name, Namespace: suggestion.Namespace, Annotations: map[string]string{}}
+		for key, value := range original.GetAnnotations() {
+			annotated.Annotations[key] = value
+		}
+		suggested := suggestion.Draft()
+		if *enforce {
+			suggested = suggestion.Annotations
+		}
+		for key, value := range suggested {
+			annotated.Annotations[key] = value
+		}
+		if patch := getAnnotationPatch(suggestion.Kind, original, annotated); patch != nil {
+			objects = append(objects, patch)
+		}
+	}
+	return writeManifests(os.Stdout, objects)
+}
+
+// writeLearningReport prints the proposed annotations with the flows they come from
+func writeLearningReport(w io.Writer, report *learning.Report, flows int) {
+	fmt.Fprintf(w, "%d flows read\n", flows)
+	for _, suggestion := range report.Suggestions {
+		name := suggestion.Name
+		if suggestion.Namespace != "" {
+			name = suggestion.Namespace + "/" + name
+		}
+		fmt.Fprintf(w, "\n%s %s\n", suggestion.Kind, name)
+		keys := []string{}
+		for key := range suggestion.Annotations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "  %s: %q\n", key, suggestion.Annotations[key])
+		}
+		for _, evidence := range suggestion.Evidence {
+			fmt.Fprintf(w, "  # %s\n", evidence)
+		}
+	}
+	if len(report.Notes) > 0 {
+		fmt.Fprintf(w, "\nTo review:\n")
+		for _, note := range report.Notes {
+			fmt.Fprintf(w, "  %s\n", note)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a msegctl subcommand, run with the arguments following its name
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"render":    {"print the policies the controllers generate for Namespace and Service manifests", runRender},
+	"can-reach": {"tell whether a pod can reach a pod or service, and which policies allow or deny it", runCanReach},
+	"diff":      {"print a unified diff between the rendered policies and the policies in the cluster", runDiff},
+	"import":    {"convert hand-written NetworkPolicies into Namespace and Service annotations", runImport},
+	"learn":     {"propose Namespace and Service annotations from observed flows", runLearn},
+	"matrix":    {"print the connectivity matrix between namespaces and services as DOT, Mermaid or JSON", runMatrix},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: msegctl <command> [flags]\n\nCommands:\n")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun msegctl <command> -h for the flags of a command.\n")
+}
+
+// exitCode is returned by a command that reported its result and exits with a code other than 0 or 1
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(c))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		if code, ok := err.(exitCode); ok {
+			os.Exit(int(code))
+		}
+		fmt.Fprintf(os.Stderr, "msegctl %s: %s\n", os.Args[1], err.Error())
+		os.Exit(1)
+	}
+}
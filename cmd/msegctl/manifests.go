@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	serializer "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// fileList collects the repeatable -f flag
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// manifests holds the objects read from YAML or JSON manifests, in the order they were read
+type manifests struct {
+	namespaces []*corev1.Namespace
+	services   []*corev1.Service
+	pods       []*corev1.Pod
+	objects    []*unstructured.Unstructured
+}
+
+// readManifests reads every document of files, - or no file at all meaning stdin. List kinds are flattened.
+func readManifests(files []string, stdin io.Reader) (*manifests, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	m := &manifests{}
+	for _, file := range files {
+		var reader io.Reader = stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			reader = f
+		}
+		decoder := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+		for {
+			raw := json.RawMessage{}
+			err := decoder.Decode(&raw)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err.Error())
+			}
+			if len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+				continue
+			}
+			err = m.add(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err.Error())
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *manifests) add(raw []byte) error {
+	obj := &unstructured.Unstructured{}
+	err := obj.UnmarshalJSON(raw)
+	if err != nil {
+		return err
+	}
+	if obj.IsList() {
+		return obj.EachListItem(func(item runtime.Object) error {
+			data, err := item.(*unstructured.Unstructured).MarshalJSON()
+			if err != nil {
+				return err
+			}
+			return m.add(data)
+		})
+	}
+	switch obj.GetKind() {
+	case "Namespace":
+		namespace := &corev1.Namespace{}
+		err = json.Unmarshal(raw, namespace)
+		m.namespaces = append(m.namespaces, namespace)
+	case "Service":
+		service := &corev1.Service{}
+		err = json.Unmarshal(raw, service)
+		if service.GetNamespace() == "" {
+			service.SetNamespace("default")
+		}
+		m.services = append(m.services, service)
+	case "Pod":
+		pod := &corev1.Pod{}
+		err = json.Unmarshal(raw, pod)
+		if pod.GetNamespace() == "" {
+			pod.SetNamespace("default")
+		}
+		m.pods = append(m.pods, pod)
+	default:
+		m.objects = append(m.objects, obj)
+	}
+	return err
+}
+
+// writeManifests prints objects as a multi-document YAML stream
+func writeManifests(w io.Writer, objects []runtime.Object) error {
+	encoder := serializer.NewYAMLSerializer(serializer.DefaultMetaFactory, nil, nil)
+	for i, obj := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		// generated objects were never stored, they have no creation timestamp
+		unstructured.RemoveNestedField(content, "metadata", "creationTimestamp")
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		err = encoder.Encode(&unstructured.Unstructured{Object: content}, w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/reachability"
+)
+
+// runMatrix prints the connectivity matrix between the namespaces and services of a snapshot
+func runMatrix(args []string) error {
+	flags := flag.NewFlagSet("matrix", flag.ExitOnError)
+	snapshotFlags := addSnapshotFlags(flags)
+	format := flags.String("o", "dot", "output format: "+strings.Join(reachability.Formats, ", "))
+	namespaces := flags.String("n", "", "comma separated namespaces to restrict the matrix to, all when omitted")
+	flags.Parse(args)
+
+	snapshot, err := snapshotFlags.load(time.Now())
+	if err != nil {
+		return err
+	}
+	included := []string{}
+	for _, namespace := range strings.Split(*namespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			included = append(included, namespace)
+		}
+	}
+	return snapshot.Matrix(included).Write(os.Stdout, *format)
+}
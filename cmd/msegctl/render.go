@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/controller/namespace"
+	"github.com/eformat/microsegmentation-operator/pkg/controller/service"
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const microsegmentationAnnotation = "microsegmentation-operator.redhat-cop.io/microsegmentation"
+
+// runRender prints the policies the namespace and service controllers generate for the manifests read,
+// rendered by the selected policy backend
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	files := fileList{}
+	flags.Var(&files, "f", "manifest file to read, - for stdin (repeatable, stdin when omitted)")
+	backendName := flags.String("backend", os.Getenv(backend.BackendEnv), "policy backend to render with: kubernetes, calico or cilium")
+	at := flags.String("at", "", "RFC3339 time to evaluate schedules and temporary access at, now when omitted")
+	flags.Parse(args)
+
+	policyBackend, err := backend.New(*backendName)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if *at != "" {
+		now, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return err
+		}
+	}
+	m, err := readManifests(files, os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	objects := []runtime.Object{}
+	for _, networkPolicy := range renderNetworkPolicies(m, now) {
+		rendered, err := backend.RenderPolicy(policyBackend, networkPolicy)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, rendered)
+	}
+	return writeManifests(os.Stdout, objects)
+}
+
+// renderNetworkPolicies returns the NetworkPolicies generated for the namespaces then the services of m. Services
+// in a namespace quarantined by its annotation generate nothing.
+func renderNetworkPolicies(m *manifests, now time.Time) []*networking.NetworkPolicy {
+	networkPolicies := []*networking.NetworkPolicy{}
+	quarantined := map[string]bool{}
+	for _, ns := range m.namespaces {
+		rendered := namespace.RenderNetworkPolicies(ns)
+		networkPolicies = append(networkPolicies, rendered...)
+		quarantined[ns.GetName()] = ns.Annotations[quarantine.Annotation] == "true"
+	}
+	for _, svc := range m.services {
+		if quarantined[svc.GetNamespace()] {
+			continue
+		}
+		if svc.Annotations[microsegmentationAnnotation] == "true" && svc.Spec.Type != corev1.ServiceTypeExternalName && len(svc.Spec.Selector) == 0 {
+			// the controller selects the pods of the endpoints, unknown offline
+			fmt.Fprintf(os.Stderr, "%s/%s: not rendered, the service has no selector\n", svc.GetNamespace(), svc.GetName())
+			continue
+		}
+		networkPolicies = append(networkPolicies, service.RenderNetworkPolicies(svc, now)...)
+	}
+	return networkPolicies
+}
+
+// renderKubernetes renders networkPolicy with the kubernetes backend, as the controllers would create it
+func renderKubernetes(networkPolicy *networking.NetworkPolicy) (*networking.NetworkPolicy, error) {
+	policyBackend, err := backend.New("kubernetes")
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := backend.RenderPolicy(policyBackend, networkPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.(*networking.NetworkPolicy), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/reachability"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// snapshotFlags selects where a reachability snapshot is read from
+type snapshotFlags struct {
+	files  fileList
+	live   *bool
+	render *bool
+}
+
+func addSnapshotFlags(flags *flag.FlagSet) *snapshotFlags {
+	s := &snapshotFlags{}
+	flags.Var(&s.files, "f", "manifest file with Namespaces, Pods, Services and NetworkPolicies, - for stdin (repeatable)")
+	s.live = flags.Bool("live", false, "read the snapshot from the cluster of the current kubeconfig instead of manifests")
+	s.render = flags.Bool("render", false, "add the NetworkPolicies the controllers generate for the Namespaces and Services of the snapshot")
+	return s
+}
+
+// load reads the snapshot, from the cluster or from manifests
+func (s *snapshotFlags) load(now time.Time) (*reachability.Snapshot, error) {
+	if *s.live {
+		c, err := newClient()
+		if err != nil {
+			return nil, err
+		}
+		snapshot, err := reachability.FromCluster(c)
+		if err != nil {
+			return nil, err
+		}
+		if *s.render {
+			m := &manifests{}
+			for i := range snapshot.Namespaces {
+				m.namespaces = append(m.namespaces, &snapshot.Namespaces[i])
+			}
+			for i := range snapshot.Services {
+				m.services = append(m.services, &snapshot.Services[i])
+			}
+			addRenderedPolicies(snapshot, m, now)
+		}
+		return snapshot, nil
+	}
+
+	m, err := readManifests(s.files, os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &reachability.Snapshot{}
+	for _, namespace := range m.namespaces {
+		// set by the API server, rendered policies select namespaces with it
+		if namespace.Labels == nil {
+			namespace.Labels = map[string]string{}
+		}
+		namespace.Labels[namespaceNameLabel] = namespace.GetName()
+		snapshot.Namespaces = append(snapshot.Namespaces, *namespace)
+	}
+	for _, service := range m.services {
+		snapshot.Services = append(snapshot.Services, *service)
+	}
+	for _, pod := range m.pods {
+		snapshot.Pods = append(snapshot.Pods, *pod)
+	}
+	for _, obj := range m.objects {
+		if obj.GetKind() != "NetworkPolicy" || obj.GroupVersionKind().Group != networking.GroupName {
+			continue
+		}
+		networkPolicy := networking.NetworkPolicy{}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &networkPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if networkPolicy.GetNamespace() == "" {
+			networkPolicy.SetNamespace("default")
+		}
+		snapshot.NetworkPolicies = append(snapshot.NetworkPolicies, networkPolicy)
+	}
+	if *s.render {
+		addRenderedPolicies(snapshot, m, now)
+	}
+	return snapshot, nil
+}
+
+// addRenderedPolicies adds the generated NetworkPolicies to snapshot, replacing policies of the same name. Quarantined
+// pods are excluded from them like the controllers do.
+func addRenderedPolicies(snapshot *reachability.Snapshot, m *manifests, now time.Time) {
+	for _, networkPolicy := range renderNetworkPolicies(m, now) {
+		rendered := networkPolicy
+		if excluded, err := renderKubernetes(networkPolicy); err == nil {
+			rendered = excluded
+		}
+		replaced := false
+		for i := range snapshot.NetworkPolicies {
+			if snapshot.NetworkPolicies[i].GetNamespace() == rendered.GetNamespace() && snapshot.NetworkPolicies[i].GetName() == rendered.GetName() {
+				snapshot.NetworkPolicies[i] = *rendered
+				replaced = true
+			}
+		}
+		if !replaced {
+			snapshot.NetworkPolicies = append(snapshot.NetworkPolicies, *rendered)
+		}
+	}
+}
+
+// newClient returns a client to the cluster of the current kubeconfig
+func newClient() (client.Client, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{Scheme: scheme.Scheme})
+}
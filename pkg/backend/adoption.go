@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AdoptLabel opts a pre-existing policy called like a generated policy in to being taken over by the operator
+const AdoptLabel = "microsegmentation-operator.redhat-cop.io/adopt"
+
+// PreviousSpecAnnotation records an adopted policy as it was, it is restored when the operator stops generating it
+const PreviousSpecAnnotation = "microsegmentation-operator.redhat-cop.io/previous-spec"
+
+// ConflictError is returned when a policy the operator does not manage has the name of a generated policy
+type ConflictError struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %s/%s exists and is not managed by the operator, label it %s=true to let the operator adopt it", e.Kind, e.Namespace, e.Name, AdoptLabel)
+}
+
+// IsConflict reports whether err is a ConflictError
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// checkAdoption returns the previous spec annotation rendered must carry to replace the existing object of the
+// same name, empty when there is none, or a ConflictError when the existing object may not be replaced
+func checkAdoption(existing *unstructured.Unstructured, owner Resource) (string, error) {
+	controller := metav1.GetControllerOf(existing)
+	if controller != nil && controller.UID == owner.GetUID() {
+		// already managed, an adopted policy keeps the record of what it was
+		return existing.GetAnnotations()[PreviousSpecAnnotation], nil
+	}
+	if controller != nil || existing.GetLabels()[AdoptLabel] != "true" {
+		return "", &ConflictError{Kind: existing.GetKind(), Namespace: existing.GetNamespace(), Name: existing.GetName()}
+	}
+	previous, err := json.Marshal(getPreviousObject(existing).Object)
+	if err != nil {
+		return "", err
+	}
+	return string(previous), nil
+}
+
+// getPreviousObject keeps what is needed to restore obj
+func getPreviousObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	previous := &unstructured.Unstructured{}
+	previous.SetGroupVersionKind(obj.GroupVersionKind())
+	previous.SetName(obj.GetName())
+	previous.SetNamespace(obj.GetNamespace())
+	previous.SetLabels(obj.GetLabels())
+	previous.SetAnnotations(obj.GetAnnotations())
+	if spec, ok := obj.Object["spec"]; ok {
+		previous.Object["spec"] = spec
+	}
+	return previous
+}
+
+// restore puts back the policy existing was adopted from, without owner
+func restore(c client.Client, existing *unstructured.Unstructured) error {
+	previous := &unstructured.Unstructured{}
+	err := json.Unmarshal([]byte(existing.GetAnnotations()[PreviousSpecAnnotation]), &previous.Object)
+	if err != nil {
+		return err
+	}
+	previous.SetResourceVersion(existing.GetResourceVersion())
+	return c.Update(context.TODO(), previous)
+}
+
+// newNotFound reports an object the operator does not manage as absent to the code deleting generated policies
+func newNotFound(gvk schema.GroupVersionKind, name string) error {
+	return errors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind}, name)
+}
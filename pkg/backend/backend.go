@@ -0,0 +1,57 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// BackendEnv is the environment variable selecting the policy backend
+const BackendEnv = "POLICY_BACKEND"
+
+// ServiceAccountLabel is a pseudo label matching the service account of a pod in peer pod selectors. Backends
+// supporting service account selectors translate it into their native selector, it matches no pod otherwise.
+const ServiceAccountLabel = "microsegmentation-operator.redhat-cop.io/service-account"
+
+// Resource is a namespaced object rendered by a backend
+type Resource interface {
+	metav1.Object
+	runtime.Object
+}
+
+// Backend renders the NetworkPolicy intent computed by the controllers into the objects enforced by
+// the cluster network plugin
+type Backend interface {
+	// Name returns the name the backend is selected by
+	Name() string
+	// GroupVersionKind returns the kind of the rendered objects
+	GroupVersionKind() schema.GroupVersionKind
+	// ObjectType returns an empty rendered object, used to watch rendered objects
+	ObjectType() runtime.Object
+	// Render returns the object enforcing networkPolicy, with the same name and namespace
+	Render(networkPolicy *networking.NetworkPolicy) (Resource, error)
+	// SupportsServiceAccountSelectors reports whether Render translates ServiceAccountLabel in peer pod selectors
+	SupportsServiceAccountSelectors() bool
+}
+
+// New returns the backend called name, an empty name selects the kubernetes backend
+func New(name string) (Backend, error) {
+	switch name {
+	case "", kubernetesBackendName:
+		return &kubernetesBackend{}, nil
+	case calicoBackendName:
+		return &calicoBackend{}, nil
+	case ciliumBackendName:
+		return &ciliumBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown policy backend %s", name)
+}
+
+// FromEnv returns the backend selected by the POLICY_BACKEND environment variable
+func FromEnv() (Backend, error) {
+	return New(os.Getenv(BackendEnv))
+}
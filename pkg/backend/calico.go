@@ -0,0 +1,232 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const calicoBackendName = "calico"
+
+// Policies allowing traffic are evaluated before the explicit deny rendered for policies without rules
+const calicoAllowOrder = 1000
+const calicoDenyOrder = 2000
+
+var calicoNetworkPolicyGVK = schema.GroupVersionKind{
+	Group:   "projectcalico.org",
+	Version: "v3",
+	Kind:    "NetworkPolicy",
+}
+
+// calicoBackend enforces the intent as projectcalico.org/v3 NetworkPolicy. Policies without rules,
+// such as deny-by-default, are rendered as explicit Deny rules ordered after all allowing policies.
+type calicoBackend struct{}
+
+func (b *calicoBackend) Name() string {
+	return calicoBackendName
+}
+
+func (b *calicoBackend) GroupVersionKind() schema.GroupVersionKind {
+	return calicoNetworkPolicyGVK
+}
+
+func (b *calicoBackend) ObjectType() runtime.Object {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(calicoNetworkPolicyGVK)
+	return obj
+}
+
+func (b *calicoBackend) SupportsServiceAccountSelectors() bool {
+	return true
+}
+
+func (b *calicoBackend) Render(networkPolicy *networking.NetworkPolicy) (Resource, error) {
+	obj := newUnstructured(calicoNetworkPolicyGVK, networkPolicy)
+
+	selector, err := calicoSelector(&networkPolicy.Spec.PodSelector)
+	if err != nil {
+		return nil, err
+	}
+	ingress, egress := policyTypes(networkPolicy)
+	types := []interface{}{}
+	spec := map[string]interface{}{
+		"selector": selector,
+		"order":    int64(calicoAllowOrder),
+	}
+
+	if ingress {
+		types = append(types, "Ingress")
+		rules := []interface{}{}
+		for _, rule := range networkPolicy.Spec.Ingress {
+			rendered, err := calicoRules(rule.From, rule.Ports, "source")
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rendered...)
+		}
+		if len(networkPolicy.Spec.Ingress) == 0 {
+			rules = append(rules, map[string]interface{}{"action": "Deny"})
+			spec["order"] = int64(calicoDenyOrder)
+		}
+		spec["ingress"] = rules
+	}
+	if egress {
+		types = append(types, "Egress")
+		rules := []interface{}{}
+		for _, rule := range networkPolicy.Spec.Egress {
+			rendered, err := calicoRules(rule.To, rule.Ports, "destination")
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rendered...)
+		}
+		if len(networkPolicy.Spec.Egress) == 0 {
+			rules = append(rules, map[string]interface{}{"action": "Deny"})
+			spec["order"] = int64(calicoDenyOrder)
+		}
+		spec["egress"] = rules
+	}
+	spec["types"] = types
+
+	obj.Object["spec"] = spec
+	return obj, nil
+}
+
+// calicoRules renders one Allow rule per peer and protocol, peerField being source or destination
+func calicoRules(peers []networking.NetworkPolicyPeer, ports []networking.NetworkPolicyPort, peerField string) ([]interface{}, error) {
+	peerEntities := []map[string]interface{}{}
+	for _, peer := range peers {
+		entity, err := calicoEntity(peer)
+		if err != nil {
+			return nil, err
+		}
+		peerEntities = append(peerEntities, entity)
+	}
+	if len(peerEntities) == 0 {
+		peerEntities = append(peerEntities, map[string]interface{}{})
+	}
+
+	grouped, protocols := portsByProtocol(ports)
+	rules := []interface{}{}
+	for _, entity := range peerEntities {
+		if len(protocols) == 0 {
+			rule := map[string]interface{}{"action": "Allow"}
+			if len(entity) > 0 {
+				rule[peerField] = entity
+			}
+			rules = append(rules, rule)
+			continue
+		}
+		for _, protocol := range protocols {
+			rule := map[string]interface{}{
+				"action":   "Allow",
+				"protocol": protocol,
+			}
+			portEntity := map[string]interface{}{}
+			if len(grouped[protocol]) > 0 {
+				portEntity["ports"] = grouped[protocol]
+			}
+			// ports always match the destination, peers the source or destination
+			if peerField == "destination" {
+				for key, value := range entity {
+					portEntity[key] = value
+				}
+			} else if len(entity) > 0 {
+				rule[peerField] = entity
+			}
+			if len(portEntity) > 0 {
+				rule["destination"] = portEntity
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func calicoEntity(peer networking.NetworkPolicyPeer) (map[string]interface{}, error) {
+	entity := map[string]interface{}{}
+	if peer.IPBlock != nil {
+		entity["nets"] = []interface{}{peer.IPBlock.CIDR}
+		if len(peer.IPBlock.Except) > 0 {
+			entity["notNets"] = toInterfaceSlice(peer.IPBlock.Except)
+		}
+		return entity, nil
+	}
+	if peer.PodSelector != nil {
+		podSelector, serviceAccounts := splitServiceAccounts(peer.PodSelector)
+		selector, err := calicoSelector(podSelector)
+		if err != nil {
+			return nil, err
+		}
+		entity["selector"] = selector
+		if serviceAccounts != nil {
+			entity["serviceAccounts"] = map[string]interface{}{"names": toInterfaceSlice(serviceAccounts)}
+		}
+	}
+	if peer.NamespaceSelector != nil {
+		selector, err := calicoSelector(peer.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+		entity["namespaceSelector"] = selector
+	}
+	return entity, nil
+}
+
+// splitServiceAccounts separates the ServiceAccountLabel requirement from a pod selector, Calico matches
+// service accounts in a dedicated field
+func splitServiceAccounts(selector *metav1.LabelSelector) (*metav1.LabelSelector, []string) {
+	var serviceAccounts []string
+	podSelector := &metav1.LabelSelector{
+		MatchLabels: selector.MatchLabels,
+	}
+	for _, requirement := range selector.MatchExpressions {
+		if requirement.Key == ServiceAccountLabel && requirement.Operator == metav1.LabelSelectorOpIn {
+			serviceAccounts = append([]string{}, requirement.Values...)
+			continue
+		}
+		podSelector.MatchExpressions = append(podSelector.MatchExpressions, requirement)
+	}
+	return podSelector, serviceAccounts
+}
+
+// calicoSelector translates a label selector into the Calico selector syntax
+func calicoSelector(selector *metav1.LabelSelector) (string, error) {
+	expressions := []string{}
+	keys := []string{}
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		expressions = append(expressions, fmt.Sprintf("%s == '%s'", key, selector.MatchLabels[key]))
+	}
+	for _, requirement := range selector.MatchExpressions {
+		values := []string{}
+		for _, value := range requirement.Values {
+			values = append(values, "'"+value+"'")
+		}
+		switch requirement.Operator {
+		case metav1.LabelSelectorOpIn:
+			expressions = append(expressions, fmt.Sprintf("%s in { %s }", requirement.Key, strings.Join(values, ", ")))
+		case metav1.LabelSelectorOpNotIn:
+			expressions = append(expressions, fmt.Sprintf("%s not in { %s }", requirement.Key, strings.Join(values, ", ")))
+		case metav1.LabelSelectorOpExists:
+			expressions = append(expressions, fmt.Sprintf("has(%s)", requirement.Key))
+		case metav1.LabelSelectorOpDoesNotExist:
+			expressions = append(expressions, fmt.Sprintf("!has(%s)", requirement.Key))
+		default:
+			return "", fmt.Errorf("unsupported label selector operator %s", requirement.Operator)
+		}
+	}
+	if len(expressions) == 0 {
+		return "all()", nil
+	}
+	return strings.Join(expressions, " && "), nil
+}
@@ -0,0 +1,190 @@
+package backend
+
+import (
+	"strconv"
+
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const ciliumBackendName = "cilium"
+
+// Cilium exposes the labels of the namespace of an endpoint under this prefix, and its namespace under the pod namespace label
+const ciliumNamespaceLabelPrefix = "io.cilium.k8s.namespace.labels."
+const ciliumPodNamespaceLabel = "io.kubernetes.pod.namespace"
+
+// Cilium labels endpoints with the service account of their pod
+const ciliumServiceAccountLabel = "io.cilium.k8s.policy.serviceaccount"
+
+var ciliumNetworkPolicyGVK = schema.GroupVersionKind{
+	Group:   "cilium.io",
+	Version: "v2",
+	Kind:    "CiliumNetworkPolicy",
+}
+
+// ciliumBackend enforces the intent as cilium.io/v2 CiliumNetworkPolicy
+type ciliumBackend struct{}
+
+func (b *ciliumBackend) Name() string {
+	return ciliumBackendName
+}
+
+func (b *ciliumBackend) GroupVersionKind() schema.GroupVersionKind {
+	return ciliumNetworkPolicyGVK
+}
+
+func (b *ciliumBackend) ObjectType() runtime.Object {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(ciliumNetworkPolicyGVK)
+	return obj
+}
+
+func (b *ciliumBackend) SupportsServiceAccountSelectors() bool {
+	return true
+}
+
+func (b *ciliumBackend) Render(networkPolicy *networking.NetworkPolicy) (Resource, error) {
+	obj := newUnstructured(ciliumNetworkPolicyGVK, networkPolicy)
+
+	spec := map[string]interface{}{
+		"endpointSelector": ciliumSelector(&networkPolicy.Spec.PodSelector),
+	}
+
+	// an empty rule puts the selected endpoints in default deny for that direction
+	ingress, egress := policyTypes(networkPolicy)
+	if ingress {
+		rules := []interface{}{}
+		for _, rule := range networkPolicy.Spec.Ingress {
+			rules = append(rules, ciliumRule(rule.From, rule.Ports, "from"))
+		}
+		if len(rules) == 0 {
+			rules = append(rules, map[string]interface{}{})
+		}
+		spec["ingress"] = rules
+	}
+	if egress {
+		rules := []interface{}{}
+		for _, rule := range networkPolicy.Spec.Egress {
+			rules = append(rules, ciliumRule(rule.To, rule.Ports, "to"))
+		}
+		if len(rules) == 0 {
+			rules = append(rules, map[string]interface{}{})
+		}
+		spec["egress"] = rules
+	}
+
+	obj.Object["spec"] = spec
+	return obj, nil
+}
+
+// ciliumRule renders a rule, direction being from or to
+func ciliumRule(peers []networking.NetworkPolicyPeer, ports []networking.NetworkPolicyPort, direction string) map[string]interface{} {
+	rule := map[string]interface{}{}
+
+	endpoints := []interface{}{}
+	cidrSet := []interface{}{}
+	for _, peer := range peers {
+		if peer.IPBlock != nil {
+			cidr := map[string]interface{}{"cidr": peer.IPBlock.CIDR}
+			if len(peer.IPBlock.Except) > 0 {
+				cidr["except"] = toInterfaceSlice(peer.IPBlock.Except)
+			}
+			cidrSet = append(cidrSet, cidr)
+			continue
+		}
+		endpoints = append(endpoints, ciliumPeerSelector(peer))
+	}
+	if len(endpoints) > 0 {
+		rule[direction+"Endpoints"] = endpoints
+	}
+	if len(cidrSet) > 0 {
+		rule[direction+"CIDRSet"] = cidrSet
+	}
+	if len(peers) == 0 {
+		rule[direction+"Entities"] = []interface{}{"all"}
+	}
+
+	if len(ports) > 0 {
+		portProtocols := []interface{}{}
+		for _, port := range ports {
+			portProtocol := map[string]interface{}{}
+			if port.Protocol != nil {
+				portProtocol["protocol"] = string(*port.Protocol)
+			}
+			if port.Port != nil {
+				if port.Port.Type == intstr.Int {
+					portProtocol["port"] = strconv.Itoa(int(port.Port.IntVal))
+				} else {
+					portProtocol["port"] = port.Port.StrVal
+				}
+			}
+			portProtocols = append(portProtocols, portProtocol)
+		}
+		rule["toPorts"] = []interface{}{map[string]interface{}{"ports": portProtocols}}
+	}
+
+	return rule
+}
+
+// ciliumPeerSelector merges the pod and namespace selectors of a peer into one endpoint selector,
+// selecting the namespace labels widens the selector beyond the policy namespace
+func ciliumPeerSelector(peer networking.NetworkPolicyPeer) map[string]interface{} {
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{},
+	}
+	if peer.PodSelector != nil {
+		for key, value := range peer.PodSelector.MatchLabels {
+			selector.MatchLabels[key] = value
+		}
+		for _, requirement := range peer.PodSelector.MatchExpressions {
+			if requirement.Key == ServiceAccountLabel {
+				requirement.Key = ciliumServiceAccountLabel
+			}
+			selector.MatchExpressions = append(selector.MatchExpressions, requirement)
+		}
+	}
+	if peer.NamespaceSelector != nil {
+		for key, value := range peer.NamespaceSelector.MatchLabels {
+			selector.MatchLabels[ciliumNamespaceLabelPrefix+key] = value
+		}
+		for _, requirement := range peer.NamespaceSelector.MatchExpressions {
+			requirement.Key = ciliumNamespaceLabelPrefix + requirement.Key
+			selector.MatchExpressions = append(selector.MatchExpressions, requirement)
+		}
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      ciliumPodNamespaceLabel,
+			Operator: metav1.LabelSelectorOpExists,
+		})
+	}
+	return ciliumSelector(selector)
+}
+
+func ciliumSelector(selector *metav1.LabelSelector) map[string]interface{} {
+	rendered := map[string]interface{}{}
+	if len(selector.MatchLabels) > 0 {
+		matchLabels := map[string]interface{}{}
+		for key, value := range selector.MatchLabels {
+			matchLabels[key] = value
+		}
+		rendered["matchLabels"] = matchLabels
+	}
+	if len(selector.MatchExpressions) > 0 {
+		matchExpressions := []interface{}{}
+		for _, requirement := range selector.MatchExpressions {
+			expression := map[string]interface{}{
+				"key":      requirement.Key,
+				"operator": string(requirement.Operator),
+			}
+			if len(requirement.Values) > 0 {
+				expression["values"] = toInterfaceSlice(requirement.Values)
+			}
+			matchExpressions = append(matchExpressions, expression)
+		}
+		rendered["matchExpressions"] = matchExpressions
+	}
+	return rendered
+}
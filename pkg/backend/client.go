@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CreateOrUpdate renders networkPolicy with b and creates or updates the result, owned by owner. An existing
+// policy of the same name not managed by owner is only replaced when it carries the adopt label, it is recorded
+// so that Delete restores it. A ConflictError is returned otherwise, and a PolicyConflict event emitted on owner.
+func CreateOrUpdate(r *util.ReconcilerBase, b Backend, owner Resource, networkPolicy *networking.NetworkPolicy) error {
+	rendered, err := RenderPolicy(b, networkPolicy)
+	if err != nil {
+		return err
+	}
+	return createOrUpdate(r, b.GroupVersionKind(), owner, rendered)
+}
+
+// CreateOrUpdateGenerated creates or updates obj, generated for owner without a backend, with the same adoption
+// rules as CreateOrUpdate
+func CreateOrUpdateGenerated(r *util.ReconcilerBase, owner Resource, obj *unstructured.Unstructured) error {
+	return createOrUpdate(r, obj.GroupVersionKind(), owner, obj)
+}
+
+func createOrUpdate(r *util.ReconcilerBase, gvk schema.GroupVersionKind, owner Resource, rendered Resource) error {
+	existing, err := get(r.GetClient(), gvk, rendered.GetNamespace(), rendered.GetName())
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		previous, err := checkAdoption(existing, owner)
+		if err != nil {
+			if IsConflict(err) {
+				r.GetRecorder().Event(owner, "Warning", "PolicyConflict", err.Error())
+			}
+			return err
+		}
+		if previous != "" {
+			annotations := rendered.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[PreviousSpecAnnotation] = previous
+			rendered.SetAnnotations(annotations)
+		}
+		if _, ok := existing.GetAnnotations()[PreviousSpecAnnotation]; !ok && previous != "" {
+			r.GetRecorder().Event(owner, "Normal", "PolicyAdopted", fmt.Sprintf("%s %s adopted, it is restored when no longer generated", existing.GetKind(), existing.GetName()))
+		}
+	}
+	return r.CreateOrUpdateResource(owner, rendered.GetNamespace(), rendered)
+}
+
+// RenderPolicy returns the object b enforces a generated networkPolicy with
+func RenderPolicy(b Backend, networkPolicy *networking.NetworkPolicy) (Resource, error) {
+	return b.Render(networkPolicy)
+}
+
+// ExcludeQuarantinedPods returns a copy of networkPolicy not applying to pods labelled as quarantined, so that no
+// generated policy allows traffic to or from them. Policies denying traffic, deny-by-default and the quarantine
+// policies, must keep applying to quarantined pods and are not excluded.
+func ExcludeQuarantinedPods(networkPolicy *networking.NetworkPolicy) *networking.NetworkPolicy {
+	excluded := networkPolicy.DeepCopy()
+	excluded.Spec.PodSelector.MatchExpressions = append(excluded.Spec.PodSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      quarantine.PodLabel,
+		Operator: metav1.LabelSelectorOpNotIn,
+		Values:   []string{"true"},
+	})
+	return excluded
+}
+
+// Delete deletes the object b renders networkPolicy into. An adopted policy is restored as it was instead, and a
+// policy the operator does not manage is left alone and reported as not found.
+func Delete(c client.Client, b Backend, networkPolicy *networking.NetworkPolicy) error {
+	return DeleteGenerated(c, b.GroupVersionKind(), networkPolicy.GetNamespace(), networkPolicy.GetName())
+}
+
+// DeleteGenerated deletes the object of kind gvk called name in namespace, restoring it when adopted and leaving
+// it alone, reported as not found, when the operator does not manage it
+func DeleteGenerated(c client.Client, gvk schema.GroupVersionKind, namespace string, name string) error {
+	existing, err := get(c, gvk, namespace, name)
+	if err != nil {
+		return err
+	}
+	if _, ok := existing.GetAnnotations()[PreviousSpecAnnotation]; ok {
+		return restore(c, existing)
+	}
+	if metav1.GetControllerOf(existing) == nil {
+		return newNotFound(gvk, name)
+	}
+	return c.Delete(context.TODO(), existing)
+}
+
+// Restore restores the policy networkPolicy was adopted from, if any, leaving generated policies alone
+func Restore(c client.Client, b Backend, networkPolicy *networking.NetworkPolicy) error {
+	existing, err := Get(c, b, networkPolicy.GetNamespace(), networkPolicy.GetName())
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if _, ok := existing.GetAnnotations()[PreviousSpecAnnotation]; !ok {
+		return nil
+	}
+	return restore(c, existing)
+}
+
+// Get returns the rendered object called name in namespace
+func Get(c client.Client, b Backend, namespace string, name string) (*unstructured.Unstructured, error) {
+	return get(c, b.GroupVersionKind(), namespace, name)
+}
+
+func get(c client.Client, gvk schema.GroupVersionKind, namespace string, name string) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	err := c.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// List returns the rendered objects in namespace carrying labels
+func List(c client.Client, b Backend, namespace string, labels map[string]string) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(b.GroupVersionKind().GroupVersion().WithKind(b.GroupVersionKind().Kind + "List"))
+	err := c.List(context.TODO(), client.InNamespace(namespace).MatchingLabels(labels), list)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
@@ -0,0 +1,32 @@
+package backend
+
+import (
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const kubernetesBackendName = "kubernetes"
+
+// kubernetesBackend enforces the intent as networking.k8s.io/v1 NetworkPolicy, as is
+type kubernetesBackend struct{}
+
+func (b *kubernetesBackend) Name() string {
+	return kubernetesBackendName
+}
+
+func (b *kubernetesBackend) GroupVersionKind() schema.GroupVersionKind {
+	return networking.SchemeGroupVersion.WithKind("NetworkPolicy")
+}
+
+func (b *kubernetesBackend) ObjectType() runtime.Object {
+	return &networking.NetworkPolicy{}
+}
+
+func (b *kubernetesBackend) SupportsServiceAccountSelectors() bool {
+	return false
+}
+
+func (b *kubernetesBackend) Render(networkPolicy *networking.NetworkPolicy) (Resource, error) {
+	return networkPolicy, nil
+}
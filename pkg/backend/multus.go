@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"strings"
+
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// PolicyForAnnotation lists the NetworkAttachmentDefinitions a MultiNetworkPolicy applies to
+const PolicyForAnnotation = "k8s.v1.cni.cncf.io/policy-for"
+
+// MultiNetworkPolicyGVK is the kind of the policies mirrored on secondary networks
+var MultiNetworkPolicyGVK = schema.GroupVersionKind{
+	Group:   "k8s.cni.cncf.io",
+	Version: "v1beta1",
+	Kind:    "MultiNetworkPolicy",
+}
+
+// GetNetworks parses a comma separated list of NetworkAttachmentDefinitions, names without a
+// namespace refer to namespace
+func GetNetworks(value string, namespace string) []string {
+	networks := []string{}
+	for _, network := range strings.Split(value, ",") {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+		if !strings.Contains(network, "/") {
+			network = namespace + "/" + network
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// RenderMultiNetworkPolicy mirrors networkPolicy as a MultiNetworkPolicy applying to networks
+func RenderMultiNetworkPolicy(networkPolicy *networking.NetworkPolicy, networks []string) (*unstructured.Unstructured, error) {
+	spec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&networkPolicy.Spec)
+	if err != nil {
+		return nil, err
+	}
+	multiNetworkPolicy := newUnstructured(MultiNetworkPolicyGVK, networkPolicy)
+	annotations := map[string]string{}
+	for key, value := range networkPolicy.GetAnnotations() {
+		annotations[key] = value
+	}
+	annotations[PolicyForAnnotation] = strings.Join(networks, ",")
+	multiNetworkPolicy.SetAnnotations(annotations)
+	multiNetworkPolicy.Object["spec"] = spec
+	return multiNetworkPolicy, nil
+}
+
+// ReconcileMultiNetworkPolicy creates or updates the MultiNetworkPolicy mirroring networkPolicy on networks,
+// owned by owner. Without networks the MultiNetworkPolicy is deleted, as Delete deletes policies.
+func ReconcileMultiNetworkPolicy(r *util.ReconcilerBase, owner Resource, networkPolicy *networking.NetworkPolicy, networks []string) error {
+	if len(networks) == 0 {
+		err := DeleteGenerated(r.GetClient(), MultiNetworkPolicyGVK, networkPolicy.GetNamespace(), networkPolicy.GetName())
+		if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return err
+		}
+		return nil
+	}
+	multiNetworkPolicy, err := RenderMultiNetworkPolicy(networkPolicy, networks)
+	if err != nil {
+		return err
+	}
+	return CreateOrUpdateGenerated(r, owner, multiNetworkPolicy)
+}
@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// newUnstructured returns an object of kind gvk carrying the metadata of networkPolicy
+func newUnstructured(gvk schema.GroupVersionKind, networkPolicy *networking.NetworkPolicy) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(networkPolicy.GetName())
+	obj.SetNamespace(networkPolicy.GetNamespace())
+	obj.SetLabels(networkPolicy.GetLabels())
+	obj.SetAnnotations(networkPolicy.GetAnnotations())
+	return obj
+}
+
+// policyTypes returns the policy types of networkPolicy, defaulted the way the API server does
+func policyTypes(networkPolicy *networking.NetworkPolicy) (bool, bool) {
+	if len(networkPolicy.Spec.PolicyTypes) == 0 {
+		return true, len(networkPolicy.Spec.Egress) > 0
+	}
+	ingress, egress := false, false
+	for _, policyType := range networkPolicy.Spec.PolicyTypes {
+		switch policyType {
+		case networking.PolicyTypeIngress:
+			ingress = true
+		case networking.PolicyTypeEgress:
+			egress = true
+		}
+	}
+	return ingress, egress
+}
+
+// portsByProtocol groups ports by protocol, TCP being the default protocol
+func portsByProtocol(ports []networking.NetworkPolicyPort) (map[string][]interface{}, []string) {
+	grouped := map[string][]interface{}{}
+	for _, port := range ports {
+		protocol := string(corev1.ProtocolTCP)
+		if port.Protocol != nil {
+			protocol = string(*port.Protocol)
+		}
+		if _, ok := grouped[protocol]; !ok {
+			grouped[protocol] = []interface{}{}
+		}
+		if port.Port == nil {
+			continue
+		}
+		if port.Port.Type == intstr.Int {
+			grouped[protocol] = append(grouped[protocol], int64(port.Port.IntVal))
+		} else {
+			grouped[protocol] = append(grouped[protocol], port.Port.StrVal)
+		}
+	}
+	protocols := []string{}
+	for protocol := range grouped {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	return grouped, protocols
+}
+
+func toInterfaceSlice(values []string) []interface{} {
+	slice := []interface{}{}
+	for _, value := range values {
+		slice = append(slice, value)
+	}
+	return slice
+}
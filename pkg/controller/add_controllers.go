@@ -2,11 +2,17 @@ package controller
 
 import (
 	"github.com/eformat/microsegmentation-operator/pkg/controller/namespace"
+	"github.com/eformat/microsegmentation-operator/pkg/controller/pod"
+	"github.com/eformat/microsegmentation-operator/pkg/controller/route"
 	"github.com/eformat/microsegmentation-operator/pkg/controller/service"
+	"github.com/eformat/microsegmentation-operator/pkg/controller/workload"
 )
 
 func init() {
 	// AddToManagerFuncs is a list of functions to create controllers and add them to a manager.
 	AddToManagerFuncs = append(AddToManagerFuncs, service.Add)
 	AddToManagerFuncs = append(AddToManagerFuncs, namespace.Add)
+	AddToManagerFuncs = append(AddToManagerFuncs, workload.Add)
+	AddToManagerFuncs = append(AddToManagerFuncs, route.Add)
+	AddToManagerFuncs = append(AddToManagerFuncs, pod.Add)
 }
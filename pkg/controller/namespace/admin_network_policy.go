@@ -0,0 +1,257 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const allowDNSAnnotation = annotationBase + "/allow-dns"
+const allowMonitoringAnnotation = annotationBase + "/allow-monitoring"
+const managedLabel = annotationBase + "/managed"
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// Environment variables configuring the cluster guardrails
+const adminNetworkPolicyEnv = "ADMIN_NETWORK_POLICY"
+const adminNetworkPolicyPriorityEnv = "ADMIN_NETWORK_POLICY_PRIORITY"
+const dnsNamespaceLabelsEnv = "DNS_NAMESPACE_LABELS"
+const dnsPortsEnv = "DNS_PORTS"
+const monitoringNamespaceLabelsEnv = "MONITORING_NAMESPACE_LABELS"
+
+const defaultAdminNetworkPolicyPriority = 50
+const defaultDNSNamespaceLabels = namespaceNameLabel + "=kube-system"
+const defaultDNSPorts = "53/UDP,53/TCP"
+const defaultMonitoringNamespaceLabels = "network.openshift.io/policy-group=monitoring"
+
+// the baseline admin network policy is a singleton and must be called default
+const baselineAdminNetworkPolicyName = "default"
+
+var adminNetworkPolicyGVK = schema.GroupVersionKind{
+	Group:   "policy.networking.k8s.io",
+	Version: "v1alpha1",
+	Kind:    "AdminNetworkPolicy",
+}
+
+var baselineAdminNetworkPolicyGVK = schema.GroupVersionKind{
+	Group:   "policy.networking.k8s.io",
+	Version: "v1alpha1",
+	Kind:    "BaselineAdminNetworkPolicy",
+}
+
+// adminNetworkPolicyConfig holds the platform defaults rendered as admin network policies
+type adminNetworkPolicyConfig struct {
+	enabled                   bool
+	priority                  int64
+	dnsNamespaceLabels        map[string]string
+	dnsPorts                  []interface{}
+	monitoringNamespaceLabels map[string]string
+}
+
+func getAdminNetworkPolicyConfig() adminNetworkPolicyConfig {
+	config := adminNetworkPolicyConfig{
+		enabled:                   os.Getenv(adminNetworkPolicyEnv) == "true",
+		priority:                  defaultAdminNetworkPolicyPriority,
+		dnsNamespaceLabels:        getLabelSelectorFromAnnotation(getEnv(dnsNamespaceLabelsEnv, defaultDNSNamespaceLabels)).MatchLabels,
+		dnsPorts:                  getAdminNetworkPolicyPorts(getEnv(dnsPortsEnv, defaultDNSPorts)),
+		monitoringNamespaceLabels: getLabelSelectorFromAnnotation(getEnv(monitoringNamespaceLabelsEnv, defaultMonitoringNamespaceLabels)).MatchLabels,
+	}
+	if value, ok := os.LookupEnv(adminNetworkPolicyPriorityEnv); ok {
+		priority, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			log.Error(err, "unable to parse admin network policy priority, using default", "priority", value)
+		} else {
+			config.priority = priority
+		}
+	}
+	return config
+}
+
+// reconcileAdminNetworkPolicies renders the allow-dns and allow-monitoring settings of namespace as an
+// AdminNetworkPolicy and keeps the deny-by-default BaselineAdminNetworkPolicy covering all enrolled namespaces.
+// Namespace admins cannot remove either of them. A quarantined namespace keeps no AdminNetworkPolicy, its rules
+// would override the quarantine.
+func (r *ReconcileNamespace) reconcileAdminNetworkPolicies(namespace *corev1.Namespace, quarantined bool) error {
+	adminNetworkPolicy := getAdminNetworkPolicy(namespace, r.adminNetworkPolicyConfig)
+	if adminNetworkPolicy != nil && !quarantined {
+		err := backend.CreateOrUpdateGenerated(&r.ReconcilerBase, namespace, adminNetworkPolicy)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := backend.DeleteGenerated(r.GetClient(), adminNetworkPolicyGVK, "", getAdminNetworkPolicyName(namespace))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	err := r.GetClient().List(context.TODO(), &client.ListOptions{}, namespaces)
+	if err != nil {
+		return err
+	}
+	enrolled := []string{}
+	for _, item := range namespaces.Items {
+		if item.Annotations[microsgmentationAnnotation] == "true" && item.ObjectMeta.DeletionTimestamp.IsZero() {
+			enrolled = append(enrolled, item.GetName())
+		}
+	}
+	sort.Strings(enrolled)
+
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(baselineAdminNetworkPolicyGVK)
+	err = r.GetClient().Get(context.TODO(), types.NamespacedName{Name: baselineAdminNetworkPolicyName}, current)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && current.GetLabels()[managedLabel] != "true" {
+		return fmt.Errorf("BaselineAdminNetworkPolicy %s exists and is not managed by the operator", baselineAdminNetworkPolicyName)
+	}
+	if len(enrolled) == 0 {
+		if err == nil {
+			return r.GetClient().Delete(context.TODO(), current)
+		}
+		return nil
+	}
+	// the baseline policy is shared by all enrolled namespaces, so it has no owner
+	baselineAdminNetworkPolicy := getBaselineAdminNetworkPolicy(enrolled)
+	if errors.IsNotFound(err) {
+		return r.GetClient().Create(context.TODO(), baselineAdminNetworkPolicy)
+	}
+	baselineAdminNetworkPolicy.SetResourceVersion(current.GetResourceVersion())
+	return r.GetClient().Update(context.TODO(), baselineAdminNetworkPolicy)
+}
+
+func getAdminNetworkPolicyName(namespace *corev1.Namespace) string {
+	return "microsegmentation-" + namespace.GetName()
+}
+
+// getAdminNetworkPolicy returns nil when the namespace allows neither DNS nor monitoring
+func getAdminNetworkPolicy(namespace *corev1.Namespace, config adminNetworkPolicyConfig) *unstructured.Unstructured {
+	if namespace.Annotations[microsgmentationAnnotation] != "true" {
+		return nil
+	}
+
+	ingress := []interface{}{}
+	if namespace.Annotations[allowMonitoringAnnotation] == "true" {
+		ingress = append(ingress, map[string]interface{}{
+			"name":   "allow-monitoring",
+			"action": "Allow",
+			"from": []interface{}{
+				map[string]interface{}{"namespaces": getUnstructuredSelector(config.monitoringNamespaceLabels)},
+			},
+		})
+	}
+	egress := []interface{}{}
+	if namespace.Annotations[allowDNSAnnotation] == "true" {
+		egress = append(egress, map[string]interface{}{
+			"name":   "allow-dns",
+			"action": "Allow",
+			"to": []interface{}{
+				map[string]interface{}{"namespaces": getUnstructuredSelector(config.dnsNamespaceLabels)},
+			},
+			"ports": config.dnsPorts,
+		})
+	}
+	if len(ingress) == 0 && len(egress) == 0 {
+		return nil
+	}
+
+	adminNetworkPolicy := &unstructured.Unstructured{}
+	adminNetworkPolicy.SetGroupVersionKind(adminNetworkPolicyGVK)
+	adminNetworkPolicy.SetName(getAdminNetworkPolicyName(namespace))
+	adminNetworkPolicy.SetLabels(map[string]string{managedLabel: "true"})
+	adminNetworkPolicy.Object["spec"] = map[string]interface{}{
+		"priority": config.priority,
+		"subject": map[string]interface{}{
+			"namespaces": getUnstructuredSelector(map[string]string{namespaceNameLabel: namespace.GetName()}),
+		},
+		"ingress": ingress,
+		"egress":  egress,
+	}
+	return adminNetworkPolicy
+}
+
+// getBaselineAdminNetworkPolicy denies ingress to the enrolled namespaces unless a NetworkPolicy allows it,
+// the same intent as the deny-by-default NetworkPolicy
+func getBaselineAdminNetworkPolicy(enrolled []string) *unstructured.Unstructured {
+	values := []interface{}{}
+	for _, name := range enrolled {
+		values = append(values, name)
+	}
+	baselineAdminNetworkPolicy := &unstructured.Unstructured{}
+	baselineAdminNetworkPolicy.SetGroupVersionKind(baselineAdminNetworkPolicyGVK)
+	baselineAdminNetworkPolicy.SetName(baselineAdminNetworkPolicyName)
+	baselineAdminNetworkPolicy.SetLabels(map[string]string{managedLabel: "true"})
+	baselineAdminNetworkPolicy.Object["spec"] = map[string]interface{}{
+		"subject": map[string]interface{}{
+			"namespaces": map[string]interface{}{
+				"matchExpressions": []interface{}{
+					map[string]interface{}{
+						"key":      namespaceNameLabel,
+						"operator": "In",
+						"values":   values,
+					},
+				},
+			},
+		},
+		"ingress": []interface{}{
+			map[string]interface{}{
+				"name":   "deny-by-default",
+				"action": "Deny",
+				"from": []interface{}{
+					map[string]interface{}{"namespaces": map[string]interface{}{}},
+				},
+			},
+		},
+	}
+	return baselineAdminNetworkPolicy
+}
+
+func getUnstructuredSelector(labels map[string]string) map[string]interface{} {
+	matchLabels := map[string]interface{}{}
+	for key, value := range labels {
+		matchLabels[key] = value
+	}
+	return map[string]interface{}{"matchLabels": matchLabels}
+}
+
+func getAdminNetworkPolicyPorts(ports string) []interface{} {
+	// this setting looks like this: 53/UDP,53/TCP
+	adminNetworkPolicyPorts := []interface{}{}
+	for _, portString := range strings.Split(ports, ",") {
+		if strings.Index(portString, "/") < 1 {
+			log.Error(fmt.Errorf("Ports: %s ", ports), "check "+dnsPortsEnv+" - missing / sign ?")
+			continue
+		}
+		port, err := strconv.ParseInt(portString[:strings.Index(portString, "/")], 10, 32)
+		if err != nil {
+			log.Error(err, "unable to convert port to integer", "port", portString)
+			continue
+		}
+		adminNetworkPolicyPorts = append(adminNetworkPolicyPorts, map[string]interface{}{
+			"portNumber": map[string]interface{}{
+				"protocol": strings.ToUpper(portString[strings.Index(portString, "/")+1:]),
+				"port":     port,
+			},
+		})
+	}
+	return adminNetworkPolicyPorts
+}
+
+func getEnv(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,143 @@
+package namespace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/eformat/microsegmentation-operator/pkg/reachability"
+	corev1 "k8s.io/api/core/v1"
+	networkv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// policyConditions is written by the operator, it is not part of the intent
+const policyConditions = annotationBase + "/policy-conditions"
+
+// maxConditionFindings is the number of findings listed in a condition message
+const maxConditionFindings = 5
+
+// policyCondition is a status condition of the NetworkPolicies of a namespace. Namespaces have no status
+// conditions on the API versions supported, so they are kept as JSON in the policyConditions annotation.
+type policyCondition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime"`
+}
+
+// conditionTypes maps the findings of the policy analysis to the condition reporting them
+var conditionTypes = []struct {
+	findingType reachability.FindingType
+	condition   string
+	description string
+}{
+	{reachability.OverlyBroad, "OverlyBroadRules", "overly broad rules"},
+	{reachability.Duplicate, "DuplicateRules", "duplicate rules"},
+	{reachability.Shadowed, "ShadowedRules", "shadowed rules"},
+}
+
+// analyzePolicies looks for overly broad, duplicate and shadowed rules among the NetworkPolicies of namespace,
+// generated or not, records them as conditions and emits an event when they change
+func (r *ReconcileNamespace) analyzePolicies(namespace *corev1.Namespace) error {
+	networkPolicies := &networkv1.NetworkPolicyList{}
+	err := r.GetClient().List(context.TODO(), client.InNamespace(namespace.GetName()), networkPolicies)
+	if err != nil {
+		return err
+	}
+	findings := reachability.Analyze(namespace, networkPolicies.Items)
+
+	previous := map[string]policyCondition{}
+	if value, ok := namespace.Annotations[policyConditions]; ok {
+		conditions := []policyCondition{}
+		// conditions that cannot be read are written again
+		if json.Unmarshal([]byte(value), &conditions) == nil {
+			for _, condition := range conditions {
+				previous[condition.Type] = condition
+			}
+		}
+	}
+
+	now := metav1.Now()
+	conditions := []policyCondition{}
+	for _, conditionType := range conditionTypes {
+		messages := []string{}
+		for _, finding := range findings {
+			if finding.Type == conditionType.findingType {
+				messages = append(messages, finding.String())
+			}
+		}
+		condition := policyCondition{
+			Type:               conditionType.condition,
+			Status:             corev1.ConditionFalse,
+			Reason:             "No" + conditionType.condition,
+			LastTransitionTime: now,
+		}
+		if len(messages) > 0 {
+			condition.Status = corev1.ConditionTrue
+			condition.Reason = conditionType.condition + "Found"
+			condition.Message = getFindingsMessage(conditionType.description, messages)
+		}
+		old, ok := previous[condition.Type]
+		if ok && old.Status == condition.Status {
+			condition.LastTransitionTime = old.LastTransitionTime
+		}
+		if condition.Status == corev1.ConditionTrue && (!ok || old.Message != condition.Message) {
+			r.GetRecorder().Event(namespace, "Warning", conditionType.condition, condition.Message)
+		}
+		if condition.Status == corev1.ConditionFalse && ok && old.Status == corev1.ConditionTrue {
+			r.GetRecorder().Event(namespace, "Normal", conditionType.condition+"Resolved", fmt.Sprintf("no more %s", conditionType.description))
+		}
+		conditions = append(conditions, condition)
+	}
+
+	value, err := json.Marshal(conditions)
+	if err != nil {
+		return err
+	}
+	if namespace.Annotations[policyConditions] == string(value) {
+		return nil
+	}
+	if namespace.Annotations == nil {
+		namespace.Annotations = map[string]string{}
+	}
+	namespace.Annotations[policyConditions] = string(value)
+	return r.GetClient().Update(context.TODO(), namespace)
+}
+
+// clearPolicyConditions removes the conditions of a namespace that is no longer analyzed
+func (r *ReconcileNamespace) clearPolicyConditions(namespace *corev1.Namespace) error {
+	if _, ok := namespace.Annotations[policyConditions]; !ok {
+		return nil
+	}
+	delete(namespace.Annotations, policyConditions)
+	return r.GetClient().Update(context.TODO(), namespace)
+}
+
+// getFindingsMessage lists the first findings of a condition
+func getFindingsMessage(description string, messages []string) string {
+	listed := messages
+	if len(listed) > maxConditionFindings {
+		listed = listed[:maxConditionFindings]
+	}
+	message := fmt.Sprintf("%d %s: %s", len(messages), description, strings.Join(listed, "; "))
+	if len(messages) > len(listed) {
+		message += fmt.Sprintf("; and %d more", len(messages)-len(listed))
+	}
+	return message
+}
+
+// policyNamespaceRequests requeues the namespace of a NetworkPolicy for analysis when it is enrolled
+func policyNamespaceRequests(c client.Client, namespace string) []reconcile.Request {
+	instance := &corev1.Namespace{}
+	err := c.Get(context.TODO(), types.NamespacedName{Name: namespace}, instance)
+	if err != nil || instance.Annotations[microsgmentationAnnotation] != "true" {
+		return []reconcile.Request{}
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: namespace}}}
+}
@@ -0,0 +1,174 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const outboundExternalCIDRs = annotationBase + "/outbound-external-cidrs"
+const outboundExternalDNSNames = annotationBase + "/outbound-external-dns-names"
+
+// both egress firewall kinds allow a single object per namespace, called default
+const egressFirewallName = "default"
+
+var egressNetworkPolicyGVK = schema.GroupVersionKind{
+	Group:   "network.openshift.io",
+	Version: "v1",
+	Kind:    "EgressNetworkPolicy",
+}
+
+var egressFirewallGVK = schema.GroupVersionKind{
+	Group:   "k8s.ovn.org",
+	Version: "v1",
+	Kind:    "EgressFirewall",
+}
+
+var networkConfigGVK = schema.GroupVersionKind{
+	Group:   "config.openshift.io",
+	Version: "v1",
+	Kind:    "Network",
+}
+
+// reconcileEgressFirewall renders the outbound-external annotations of namespace into the egress firewall
+// object of the cluster SDN: EgressNetworkPolicy on openshift-sdn, EgressFirewall on OVN-Kubernetes. Firewalls
+// not managed by the operator are left alone, and only reported when the namespace has external egress settings.
+func (r *ReconcileNamespace) reconcileEgressFirewall(namespace *corev1.Namespace) error {
+	requested := getEgressFirewall(namespace, egressFirewallGVK) != nil
+	gvk, err := r.getEgressFirewallGVK()
+	if err != nil {
+		if requested {
+			r.GetRecorder().Event(namespace, "Warning", "EgressFirewallUnavailable", fmt.Sprintf("external egress is not enforced: %v", err))
+		}
+		// nothing to enforce, nothing to clean up
+		return nil
+	}
+
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(gvk)
+	err = r.GetClient().Get(context.TODO(), types.NamespacedName{Namespace: namespace.GetName(), Name: egressFirewallName}, current)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if exists && current.GetLabels()[managedLabel] != "true" {
+		if requested {
+			r.GetRecorder().Event(namespace, "Warning", "EgressFirewallConflict", fmt.Sprintf("external egress is not enforced: %s %s exists and is not managed by the operator", gvk.Kind, egressFirewallName))
+		}
+		return nil
+	}
+
+	egressFirewall := getEgressFirewall(namespace, gvk)
+	if egressFirewall == nil {
+		if exists {
+			return r.GetClient().Delete(context.TODO(), current)
+		}
+		return nil
+	}
+	return r.CreateOrUpdateResource(namespace, namespace.GetName(), egressFirewall)
+}
+
+// getEgressFirewallGVK detects the SDN from the cluster network configuration, falling back to the
+// available APIs. The result is cached, the SDN does not change while the operator runs.
+func (r *ReconcileNamespace) getEgressFirewallGVK() (schema.GroupVersionKind, error) {
+	if r.egressFirewallGVK != nil {
+		return *r.egressFirewallGVK, nil
+	}
+
+	networkConfig := &unstructured.Unstructured{}
+	networkConfig.SetGroupVersionKind(networkConfigGVK)
+	err := r.GetClient().Get(context.TODO(), types.NamespacedName{Name: "cluster"}, networkConfig)
+	if err == nil {
+		networkType, _, _ := unstructured.NestedString(networkConfig.Object, "status", "networkType")
+		switch networkType {
+		case "OpenShiftSDN":
+			r.egressFirewallGVK = &egressNetworkPolicyGVK
+			return egressNetworkPolicyGVK, nil
+		case "OVNKubernetes":
+			r.egressFirewallGVK = &egressFirewallGVK
+			return egressFirewallGVK, nil
+		}
+	} else if !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return schema.GroupVersionKind{}, err
+	}
+
+	for _, gvk := range []schema.GroupVersionKind{egressFirewallGVK, egressNetworkPolicyGVK} {
+		gvk := gvk
+		if _, err := r.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+			r.egressFirewallGVK = &gvk
+			return gvk, nil
+		}
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("neither %s nor %s is available, unable to enforce external egress", egressFirewallGVK.Kind, egressNetworkPolicyGVK.Kind)
+}
+
+// getEgressFirewall returns nil when the namespace restricts no external egress
+func getEgressFirewall(namespace *corev1.Namespace, gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	if namespace.Annotations[microsgmentationAnnotation] != "true" {
+		return nil
+	}
+	cidrs := getListFromAnnotation(namespace.Annotations[outboundExternalCIDRs])
+	dnsNames := getListFromAnnotation(namespace.Annotations[outboundExternalDNSNames])
+	if len(cidrs) == 0 && len(dnsNames) == 0 {
+		return nil
+	}
+
+	// rules are evaluated in order, anything not explicitly allowed is denied
+	egress := []interface{}{}
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			log.Error(err, "unable to parse external cidr", "cidr", cidr)
+			continue
+		}
+		egress = append(egress, map[string]interface{}{
+			"type": "Allow",
+			"to":   map[string]interface{}{"cidrSelector": cidr},
+		})
+	}
+	for _, dnsName := range dnsNames {
+		egress = append(egress, map[string]interface{}{
+			"type": "Allow",
+			"to":   map[string]interface{}{"dnsName": dnsName},
+		})
+	}
+	egress = append(egress, map[string]interface{}{
+		"type": "Deny",
+		"to":   map[string]interface{}{"cidrSelector": "0.0.0.0/0"},
+	})
+	// openshift-sdn is IPv4 only, OVN-Kubernetes clusters can be dual-stack
+	if gvk == egressFirewallGVK {
+		egress = append(egress, map[string]interface{}{
+			"type": "Deny",
+			"to":   map[string]interface{}{"cidrSelector": "::/0"},
+		})
+	}
+
+	egressFirewall := &unstructured.Unstructured{}
+	egressFirewall.SetGroupVersionKind(gvk)
+	egressFirewall.SetName(egressFirewallName)
+	egressFirewall.SetNamespace(namespace.GetName())
+	egressFirewall.SetLabels(map[string]string{managedLabel: "true"})
+	egressFirewall.Object["spec"] = map[string]interface{}{
+		"egress": egress,
+	}
+	return egressFirewall
+}
+
+func getListFromAnnotation(value string) []string {
+	// this annotation looks like this: value1,value2
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
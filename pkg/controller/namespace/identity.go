@@ -0,0 +1,75 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Environment variable naming the label the controller maintains on enrolled namespaces without the
+// kubernetes.io/metadata.name label
+const namespaceIdentityLabelEnv = "NAMESPACE_IDENTITY_LABEL"
+
+const defaultNamespaceIdentityLabel = annotationBase + "/namespace"
+
+// getConfiguredIdentityLabel returns the identity label the controller maintains
+func getConfiguredIdentityLabel() string {
+	return getEnv(namespaceIdentityLabelEnv, defaultNamespaceIdentityLabel)
+}
+
+// getIdentityLabel returns the label selecting namespace alone: kubernetes.io/metadata.name, set by the API server
+// since Kubernetes 1.21, or identityLabel, maintained by the controller, on older clusters
+func getIdentityLabel(namespace *corev1.Namespace, identityLabel string) string {
+	if namespace.Labels[namespaceNameLabel] == namespace.GetName() {
+		return namespaceNameLabel
+	}
+	return identityLabel
+}
+
+// ensureIdentityLabel labels namespace with its name when the API server does not
+func (r *ReconcileNamespace) ensureIdentityLabel(namespace *corev1.Namespace) error {
+	label := getIdentityLabel(namespace, r.identityLabel)
+	if namespace.Labels[label] == namespace.GetName() {
+		return nil
+	}
+	if namespace.Labels == nil {
+		namespace.Labels = map[string]string{}
+	}
+	namespace.Labels[label] = namespace.GetName()
+	err := r.GetClient().Update(context.TODO(), namespace)
+	if err != nil {
+		return err
+	}
+	r.GetRecorder().Event(namespace, "Normal", "NamespaceIdentityLabelled", fmt.Sprintf("labelled %s=%s, for policies to select the namespace", label, namespace.GetName()))
+	return nil
+}
+
+// verifyNamespaceSelectors checks that the namespace selectors of networkPolicy, meant to select namespace, do.
+// The namespace is read from the API server, so that the labels just set by ensureIdentityLabel are those stored.
+func (r *ReconcileNamespace) verifyNamespaceSelectors(namespace *corev1.Namespace, networkPolicy *networkv1.NetworkPolicy) error {
+	current := &corev1.Namespace{}
+	err := r.apiReader.Get(context.TODO(), types.NamespacedName{Name: namespace.GetName()}, current)
+	if err != nil {
+		return err
+	}
+	for _, rule := range networkPolicy.Spec.Ingress {
+		for _, peer := range rule.From {
+			if peer.NamespaceSelector == nil {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+			if err != nil {
+				return err
+			}
+			if !selector.Matches(labels.Set(current.GetLabels())) {
+				return fmt.Errorf("NetworkPolicy %s selects namespaces with %s, which does not match namespace %s", networkPolicy.GetName(), selector.String(), namespace.GetName())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package namespace
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImportNetworkPolicy adds to the annotations of namespace the settings generating the equivalent of networkPolicy,
+// a policy applying to every pod of the namespace. Namespace labels are merged with the ones already annotated.
+// It returns the names of the patterns recognised, or an error describing what the annotations cannot express, in
+// which case namespace is left unchanged.
+func ImportNetworkPolicy(namespace *corev1.Namespace, networkPolicy *networkv1.NetworkPolicy) ([]string, error) {
+	if !isEmptySelector(&networkPolicy.Spec.PodSelector) {
+		return nil, fmt.Errorf("selects some pods of the namespace only")
+	}
+	ingress, egress := getPolicyTypes(networkPolicy)
+	if !ingress {
+		return nil, fmt.Errorf("does not restrict ingress, namespace rules deny ingress by default")
+	}
+	if egress && len(networkPolicy.Spec.Egress) == 0 {
+		return nil, fmt.Errorf("denies all egress, namespaces only restrict egress to namespace labels")
+	}
+
+	patterns := []string{}
+	allowFromSelf := false
+	inbound := []string{}
+	for _, rule := range networkPolicy.Spec.Ingress {
+		if len(rule.Ports) > 0 {
+			return nil, fmt.Errorf("ingress rule restricted to ports, namespace rules allow every port")
+		}
+		if len(rule.From) == 0 {
+			return nil, fmt.Errorf("ingress rule allowing all sources")
+		}
+		for _, peer := range rule.From {
+			if isSelfPeer(namespace, peer) {
+				allowFromSelf = true
+				continue
+			}
+			label, err := getNamespaceLabelPeer(peer)
+			if err != nil {
+				return nil, fmt.Errorf("ingress %s", err.Error())
+			}
+			inbound = append(inbound, label)
+		}
+	}
+	outbound := []string{}
+	for _, rule := range networkPolicy.Spec.Egress {
+		if len(rule.Ports) > 0 {
+			return nil, fmt.Errorf("egress rule restricted to ports, namespace rules allow every port")
+		}
+		if len(rule.To) == 0 {
+			return nil, fmt.Errorf("egress rule allowing all destinations")
+		}
+		for _, peer := range rule.To {
+			label, err := getNamespaceLabelPeer(peer)
+			if err != nil {
+				return nil, fmt.Errorf("egress %s", err.Error())
+			}
+			outbound = append(outbound, label)
+		}
+	}
+
+	if namespace.Annotations == nil {
+		namespace.Annotations = map[string]string{}
+	}
+	// an ingress policy denies whatever it does not allow, as enrolling the namespace does
+	if namespace.Annotations[microsgmentationAnnotation] != "true" {
+		patterns = append(patterns, "deny-by-default")
+		namespace.Annotations[microsgmentationAnnotation] = "true"
+	}
+	if allowFromSelf {
+		patterns = append(patterns, "allow-from-self")
+		namespace.Annotations[allowFromSelfLabel] = "true"
+	}
+	if len(inbound) > 0 {
+		patterns = append(patterns, "namespace-label ingress")
+		namespace.Annotations[inboundNamespaceLabels] = mergeLabels(namespace.Annotations[inboundNamespaceLabels], inbound)
+	}
+	if len(outbound) > 0 {
+		patterns = append(patterns, "namespace-label egress")
+		namespace.Annotations[outboundNamespaceLabels] = mergeLabels(namespace.Annotations[outboundNamespaceLabels], outbound)
+	}
+	return patterns, nil
+}
+
+// isSelfPeer reports whether peer selects every pod of namespace and only them
+func isSelfPeer(namespace *corev1.Namespace, peer networkv1.NetworkPolicyPeer) bool {
+	if peer.IPBlock != nil || (peer.PodSelector != nil && !isEmptySelector(peer.PodSelector)) {
+		return false
+	}
+	if peer.NamespaceSelector == nil {
+		return peer.PodSelector != nil
+	}
+	if len(peer.NamespaceSelector.MatchExpressions) > 0 || len(peer.NamespaceSelector.MatchLabels) != 1 {
+		return false
+	}
+	for key, value := range peer.NamespaceSelector.MatchLabels {
+		return value == namespace.GetName() && (key == namespaceNameLabel || key == getConfiguredIdentityLabel() || key == "name")
+	}
+	return false
+}
+
+// getNamespaceLabelPeer returns the label=value of a peer selecting every pod of the namespaces carrying a label
+func getNamespaceLabelPeer(peer networkv1.NetworkPolicyPeer) (string, error) {
+	switch {
+	case peer.IPBlock != nil:
+		return "", fmt.Errorf("rule with an ipBlock peer")
+	case peer.NamespaceSelector == nil:
+		return "", fmt.Errorf("rule with a pod selector peer, use service annotations")
+	case peer.PodSelector != nil && !isEmptySelector(peer.PodSelector):
+		return "", fmt.Errorf("rule selecting some pods of other namespaces")
+	case len(peer.NamespaceSelector.MatchExpressions) > 0:
+		return "", fmt.Errorf("rule with a namespace selector expression")
+	case len(peer.NamespaceSelector.MatchLabels) != 1:
+		return "", fmt.Errorf("rule with a namespace selector not made of a single label")
+	}
+	for key, value := range peer.NamespaceSelector.MatchLabels {
+		if strings.Contains(value, ",") {
+			return "", fmt.Errorf("rule with a namespace label value containing a comma")
+		}
+		return key + "=" + value, nil
+	}
+	return "", nil
+}
+
+// mergeLabels adds labels to the label=value list of an annotation, keeping them sorted and unique
+func mergeLabels(annotation string, labels []string) string {
+	merged := map[string]bool{}
+	for _, label := range append(strings.Split(annotation, ","), labels...) {
+		if label = strings.TrimSpace(label); label != "" {
+			merged[label] = true
+		}
+	}
+	result := []string{}
+	for label := range merged {
+		result = append(result, label)
+	}
+	sort.Strings(result)
+	return strings.Join(result, ",")
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+// getPolicyTypes returns the policy types of networkPolicy, defaulted the way the API server does
+func getPolicyTypes(networkPolicy *networkv1.NetworkPolicy) (bool, bool) {
+	if len(networkPolicy.Spec.PolicyTypes) == 0 {
+		return true, len(networkPolicy.Spec.Egress) > 0
+	}
+	ingress, egress := false, false
+	for _, policyType := range networkPolicy.Spec.PolicyTypes {
+		switch policyType {
+		case networkv1.PolicyTypeIngress:
+			ingress = true
+		case networkv1.PolicyTypeEgress:
+			egress = true
+		}
+	}
+	return ingress, egress
+}
@@ -2,19 +2,26 @@ package namespace
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 
 	networkv1 "k8s.io/api/networking/v1"
 
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
 	"github.com/redhat-cop/operator-utils/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -32,23 +39,44 @@ const microsgmentationAnnotation = annotationBase + "/microsegmentation"
 const inboundNamespaceLabels = annotationBase + "/inbound-namespace-labels"
 const outboundNamespaceLabels = annotationBase + "/outbound-namespace-labels"
 const allowFromSelfLabel = annotationBase + "/allow-from-self"
+const networkAttachmentDefinitions = annotationBase + "/network-attachment-definitions"
 const controllerName = "namespace-controller"
 
+// generatedFrom is written by the operator on the policies it generates, it is not part of the intent
+const generatedFrom = annotationBase + "/generated-from"
+
+// ruleSources describes the namespace annotations a generated policy and each of its rules come from, in the
+// order of the rules
+type ruleSources struct {
+	Policy  string   `json:"policy"`
+	Ingress []string `json:"ingress,omitempty"`
+	Egress  []string `json:"egress,omitempty"`
+}
+
 // Add creates a new Namespace Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	policyBackend, err := backend.FromEnv()
+	if err != nil {
+		return err
+	}
+	return add(mgr, newReconciler(mgr, policyBackend), policyBackend)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager, policyBackend backend.Backend) reconcile.Reconciler {
 	return &ReconcileNamespace{
-		ReconcilerBase: util.NewReconcilerBase(mgr.GetClient(), mgr.GetScheme(), mgr.GetConfig(), mgr.GetRecorder(controllerName)),
+		ReconcilerBase:           util.NewReconcilerBase(mgr.GetClient(), mgr.GetScheme(), mgr.GetConfig(), mgr.GetRecorder(controllerName)),
+		backend:                  policyBackend,
+		adminNetworkPolicyConfig: getAdminNetworkPolicyConfig(),
+		restMapper:               mgr.GetRESTMapper(),
+		identityLabel:            getConfiguredIdentityLabel(),
+		apiReader:                mgr.GetAPIReader(),
 	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+func add(mgr manager.Manager, r reconcile.Reconciler, policyBackend backend.Backend) error {
 	// Create a new controller
 	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: r})
 	if err != nil {
@@ -73,7 +101,7 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 			newValueAS, _ := e.MetaNew.GetAnnotations()[allowFromSelfLabel]
 			oldAS := oldValueAS == "true"
 			newAS := newValueAS == "true"
-			return (oldMS != newMS) || (oldAS != newAS)
+			return (oldMS != newMS) || (oldAS != newAS) || !reflect.DeepEqual(getAnnotations(e.MetaOld), getAnnotations(e.MetaNew))
 		},
 		CreateFunc: func(e event.CreateEvent) bool {
 			_, ok := e.Object.(*corev1.Namespace)
@@ -82,7 +110,8 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 			}
 			value, _ := e.Meta.GetAnnotations()[microsgmentationAnnotation]
 			value2, _ := e.Meta.GetAnnotations()[allowFromSelfLabel]
-			return value == "true" || value2 == "true"
+			value3, _ := e.Meta.GetAnnotations()[quarantine.Annotation]
+			return value == "true" || value2 == "true" || value3 == "true"
 		},
 	}
 
@@ -92,8 +121,18 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to the cluster level quarantine trigger and requeue all Namespaces
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return quarantineTriggerRequests(mgr.GetClient(), a.Meta.GetNamespace(), a.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
 	// Watch for changes to secondary resource and requeue the owner Namespace
-	err = c.Watch(&source.Kind{Type: &networkv1.NetworkPolicy{}}, &handler.EnqueueRequestForOwner{
+	err = c.Watch(&source.Kind{Type: policyBackend.ObjectType()}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &corev1.Namespace{},
 	})
@@ -101,6 +140,16 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to any NetworkPolicy and requeue its Namespace for analysis
+	err = c.Watch(&source.Kind{Type: &networkv1.NetworkPolicy{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return policyNamespaceRequests(mgr.GetClient(), a.Meta.GetNamespace())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -109,6 +158,13 @@ var _ reconcile.Reconciler = &ReconcileNamespace{}
 // ReconcileNamespace reconciles a Namespace object
 type ReconcileNamespace struct {
 	util.ReconcilerBase
+	backend                  backend.Backend
+	adminNetworkPolicyConfig adminNetworkPolicyConfig
+	restMapper               meta.RESTMapper
+	egressFirewallGVK        *schema.GroupVersionKind
+	identityLabel            string
+	// apiReader reads namespaces from the API server, the cache may not have seen their identity label yet
+	apiReader client.Reader
 }
 
 // Reconcile reads that state of the cluster for a Namespace object and makes changes based on the state read
@@ -140,64 +196,162 @@ func (r *ReconcileNamespace) Reconcile(request reconcile.Request) (reconcile.Res
 		return reconcile.Result{}, nil
 	}
 
+	quarantined, err := quarantine.IsQuarantined(r.GetClient(), instance)
+	if err != nil {
+		log.Error(err, "unable to check quarantine")
+		return r.manageError(err, instance)
+	}
+
+	// Cluster guardrails namespace admins cannot remove
+	if r.adminNetworkPolicyConfig.enabled {
+		err = r.reconcileAdminNetworkPolicies(instance, quarantined)
+		if err != nil {
+			log.Error(err, "unable to reconcile AdminNetworkPolicies")
+			return r.manageError(err, instance)
+		}
+	}
+
+	// External egress, enforced by the OpenShift SDN. The other policies of the namespace do not depend on it, a
+	// failure is reported once they are reconciled.
+	egressFirewallErr := r.reconcileEgressFirewall(instance)
+	if egressFirewallErr != nil {
+		log.Error(egressFirewallErr, "unable to reconcile egress firewall")
+	}
+
+	// Break-glass isolation replaces every generated policy
+	if quarantined {
+		err = r.reconcileQuarantine(instance)
+		if err != nil {
+			log.Error(err, "unable to quarantine namespace")
+			return r.manageError(err, instance)
+		}
+		return reconcile.Result{}, nil
+	}
+	err = r.liftQuarantine(instance)
+	if err != nil {
+		log.Error(err, "unable to lift namespace quarantine")
+		return r.manageError(err, instance)
+	}
+
 	// Define a default deny all networkpolicy
+	defaultNetworkPolicy := getDenyDefaultNetworkPolicy(instance)
 	if instance.Annotations[microsgmentationAnnotation] == "true" {
-		defaultNetworkPolicy := getDenyDefaultNetworkPolicy(instance)
-		err = r.CreateOrUpdateResource(instance, instance.GetNamespace(), defaultNetworkPolicy)
+		err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, instance, defaultNetworkPolicy)
 		if err != nil {
 			log.Error(err, "unable to create DefaultDenyNetworkPolicy", "NetworkPolicy", defaultNetworkPolicy)
 			return r.manageError(err, instance)
 		}
 	}
 
+	// Namespaces selecting themselves need a label with their name
+	if instance.Annotations[microsgmentationAnnotation] == "true" {
+		err = r.ensureIdentityLabel(instance)
+		if err != nil {
+			log.Error(err, "unable to label namespace with its name")
+			return r.manageError(err, instance)
+		}
+	}
+
 	// Namespace Network Policies
 	networkPolicy := getNetworkPolicy(instance)
-	allowFromSelfNetworkPolicy := getAllowFromSelfNetworkPolicy(instance)
+	allowFromSelfNetworkPolicy := getAllowFromSelfNetworkPolicy(instance, getIdentityLabel(instance, r.identityLabel))
+	if instance.Annotations[microsgmentationAnnotation] == "true" && instance.Annotations[allowFromSelfLabel] == "true" {
+		err = r.verifyNamespaceSelectors(instance, allowFromSelfNetworkPolicy)
+		if err != nil {
+			log.Error(err, "AllowFromSelfNetworkPolicy does not select its namespace", "NetworkPolicy", allowFromSelfNetworkPolicy)
+			return r.manageError(err, instance)
+		}
+	}
+
+	// Mirror the policies on the secondary networks of the namespace
+	enabled := instance.Annotations[microsgmentationAnnotation] == "true"
+	networks := backend.GetNetworks(instance.Annotations[networkAttachmentDefinitions], instance.GetName())
+	for _, mirrored := range []struct {
+		networkPolicy *networkv1.NetworkPolicy
+		enabled       bool
+	}{
+		{defaultNetworkPolicy, enabled},
+		{backend.ExcludeQuarantinedPods(networkPolicy), enabled},
+		{backend.ExcludeQuarantinedPods(allowFromSelfNetworkPolicy), enabled && instance.Annotations[allowFromSelfLabel] == "true"},
+	} {
+		policyNetworks := networks
+		if !mirrored.enabled {
+			policyNetworks = nil
+		}
+		err = backend.ReconcileMultiNetworkPolicy(&r.ReconcilerBase, instance, mirrored.networkPolicy, policyNetworks)
+		if err != nil {
+			log.Error(err, "unable to reconcile MultiNetworkPolicy", "NetworkPolicy", mirrored.networkPolicy)
+			return r.manageError(err, instance)
+		}
+	}
 
 	if instance.Annotations[microsgmentationAnnotation] == "true" {
-		err = r.CreateOrUpdateResource(instance, instance.GetNamespace(), networkPolicy)
+		err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, instance, backend.ExcludeQuarantinedPods(networkPolicy))
 		if err != nil {
 			log.Error(err, "unable to create NetworkPolicy", "NetworkPolicy", networkPolicy)
 			return r.manageError(err, instance)
 		}
 		if instance.Annotations[allowFromSelfLabel] == "true" {
-			err = r.CreateOrUpdateResource(instance, instance.GetNamespace(), allowFromSelfNetworkPolicy)
+			err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, instance, backend.ExcludeQuarantinedPods(allowFromSelfNetworkPolicy))
 			if err != nil {
 				log.Error(err, "unable to create AllowFromSelfNetworkPolicy", "NetworkPolicy", allowFromSelfNetworkPolicy)
 				return r.manageError(err, instance)
 			}
 		} else {
-			err = r.GetClient().Delete(context.TODO(), allowFromSelfNetworkPolicy)
-			if err != nil {
-				if errors.IsNotFound(err) {
-					return reconcile.Result{}, nil
-				}
+			err = backend.Delete(r.GetClient(), r.backend, allowFromSelfNetworkPolicy)
+			if err != nil && !errors.IsNotFound(err) {
 				log.Error(err, "unable to delete AllowFromSelfNetworkPolicy", "NetworkPolicy", allowFromSelfNetworkPolicy)
 				return r.manageError(err, instance)
 			}
 		}
+
+		// Report the rules made moot by others, among generated and hand-written policies
+		err = r.analyzePolicies(instance)
+		if err != nil {
+			log.Error(err, "unable to analyze NetworkPolicies")
+			return r.manageError(err, instance)
+		}
 	} else {
-		err = r.GetClient().Delete(context.TODO(), networkPolicy)
+		// the deny by default policy stays, unless it was adopted
+		err = backend.Restore(r.GetClient(), r.backend, defaultNetworkPolicy)
 		if err != nil {
-			if errors.IsNotFound(err) {
-				return reconcile.Result{}, nil
-			}
+			log.Error(err, "unable to restore adopted DefaultDenyNetworkPolicy", "NetworkPolicy", defaultNetworkPolicy)
+			return r.manageError(err, instance)
+		}
+		err = backend.Delete(r.GetClient(), r.backend, networkPolicy)
+		if err != nil && !errors.IsNotFound(err) {
 			log.Error(err, "unable to delete NetworkPolicy", "NetworkPolicy", networkPolicy)
 			return r.manageError(err, instance)
 		}
-		err = r.GetClient().Delete(context.TODO(), allowFromSelfNetworkPolicy)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return reconcile.Result{}, nil
-			}
+		err = backend.Delete(r.GetClient(), r.backend, allowFromSelfNetworkPolicy)
+		if err != nil && !errors.IsNotFound(err) {
 			log.Error(err, "unable to delete AllowFromSelfNetworkPolicy", "NetworkPolicy", allowFromSelfNetworkPolicy)
 			return r.manageError(err, instance)
 		}
+		err = r.clearPolicyConditions(instance)
+		if err != nil {
+			log.Error(err, "unable to clear NetworkPolicy conditions")
+			return r.manageError(err, instance)
+		}
 	}
 
+	if egressFirewallErr != nil {
+		return r.manageError(egressFirewallErr, instance)
+	}
 	return reconcile.Result{}, nil
 }
 
+// getAnnotations returns the microsegmentation annotations of a namespace
+func getAnnotations(namespace metav1.Object) map[string]string {
+	annotations := map[string]string{}
+	for key, value := range namespace.GetAnnotations() {
+		if strings.HasPrefix(key, annotationBase+"/") && key != policyConditions && key != quarantineRecorded {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
 func getDenyDefaultNetworkPolicy(namespace *corev1.Namespace) *networkv1.NetworkPolicy {
 	defaultNetworkPolicy := &networkv1.NetworkPolicy{
 		TypeMeta: metav1.TypeMeta{
@@ -207,6 +361,9 @@ func getDenyDefaultNetworkPolicy(namespace *corev1.Namespace) *networkv1.Network
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "deny-by-default",
 			Namespace: namespace.GetName(),
+			Annotations: map[string]string{
+				generatedFrom: getRuleSources(ruleSources{Policy: getAnnotationSource(microsgmentationAnnotation, "true")}),
+			},
 		},
 		Spec: networkv1.NetworkPolicySpec{
 			PodSelector: metav1.LabelSelector{},
@@ -219,7 +376,8 @@ func getDenyDefaultNetworkPolicy(namespace *corev1.Namespace) *networkv1.Network
 	return defaultNetworkPolicy
 }
 
-func getAllowFromSelfNetworkPolicy(namespace *corev1.Namespace) *networkv1.NetworkPolicy {
+// getAllowFromSelfNetworkPolicy allows the pods of namespace, selected by its identityLabel
+func getAllowFromSelfNetworkPolicy(namespace *corev1.Namespace, identityLabel string) *networkv1.NetworkPolicy {
 	allowFromSelfNetworkPolicy := &networkv1.NetworkPolicy{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "networking.k8s.io/v1",
@@ -228,6 +386,12 @@ func getAllowFromSelfNetworkPolicy(namespace *corev1.Namespace) *networkv1.Netwo
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "allow-from-self",
 			Namespace: namespace.GetName(),
+			Annotations: map[string]string{
+				generatedFrom: getRuleSources(ruleSources{
+					Policy:  getAnnotationSource(allowFromSelfLabel, "true"),
+					Ingress: []string{getAnnotationSource(allowFromSelfLabel, "true")},
+				}),
+			},
 		},
 		Spec: networkv1.NetworkPolicySpec{
 			PodSelector: metav1.LabelSelector{},
@@ -238,7 +402,9 @@ func getAllowFromSelfNetworkPolicy(namespace *corev1.Namespace) *networkv1.Netwo
 
 	networkPolicyIngressRule := networkv1.NetworkPolicyIngressRule{
 		From: []networkv1.NetworkPolicyPeer{networkv1.NetworkPolicyPeer{
-			NamespaceSelector: getLabelSelectorFromAnnotation("name=" + namespace.GetName()),
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{identityLabel: namespace.GetName()},
+			},
 		}},
 	}
 	allowFromSelfNetworkPolicy.Spec.Ingress = append(allowFromSelfNetworkPolicy.Spec.Ingress, networkPolicyIngressRule)
@@ -263,18 +429,47 @@ func getNetworkPolicy(namespace *corev1.Namespace) *networkv1.NetworkPolicy {
 		},
 	}
 
-	if inboundNamespaceLabels, ok := namespace.Annotations[inboundNamespaceLabels]; ok {
+	sources := ruleSources{Policy: getAnnotationSource(microsgmentationAnnotation, "true")}
+	if labels, ok := namespace.Annotations[inboundNamespaceLabels]; ok {
 		networkPolicy.ObjectMeta.Name = "ingress-from-namespaces"
-		networkPolicy.Spec.Ingress = getIngressRulesFromLabels(inboundNamespaceLabels)
+		networkPolicy.Spec.Ingress = getIngressRulesFromLabels(labels)
+		for _, rule := range networkPolicy.Spec.Ingress {
+			sources.Ingress = append(sources.Ingress, getPeerSource(inboundNamespaceLabels, rule.From[0]))
+		}
 	}
-	if outboundNamespaceLabels, ok := namespace.Annotations[outboundNamespaceLabels]; ok {
+	if labels, ok := namespace.Annotations[outboundNamespaceLabels]; ok {
 		networkPolicy.ObjectMeta.Name = "egress-to-namespaces"
-		networkPolicy.Spec.Egress = getEgressRulesFromLabels(outboundNamespaceLabels)
+		networkPolicy.Spec.Egress = getEgressRulesFromLabels(labels)
+		for _, rule := range networkPolicy.Spec.Egress {
+			sources.Egress = append(sources.Egress, getPeerSource(outboundNamespaceLabels, rule.To[0]))
+		}
 	}
+	networkPolicy.ObjectMeta.Annotations = map[string]string{generatedFrom: getRuleSources(sources)}
 
 	return networkPolicy
 }
 
+// getPeerSource returns the label of annotation a namespace rule peer comes from
+func getPeerSource(annotation string, peer networkv1.NetworkPolicyPeer) string {
+	for label, value := range peer.NamespaceSelector.MatchLabels {
+		return getAnnotationSource(annotation, label+"="+value)
+	}
+	return getAnnotationSource(annotation, "")
+}
+
+// getAnnotationSource formats an annotation setting as recorded in the generatedFrom annotation
+func getAnnotationSource(annotation string, value string) string {
+	return strings.TrimPrefix(annotation, annotationBase+"/") + ": " + value
+}
+
+func getRuleSources(sources ruleSources) string {
+	value, err := json.Marshal(sources)
+	if err != nil {
+		return ""
+	}
+	return string(value)
+}
+
 /*
    - from:
      - namespaceSelector:
@@ -285,14 +480,11 @@ func getNetworkPolicy(namespace *corev1.Namespace) *networkv1.NetworkPolicy {
          matchLabels:
            key2: value2
 */
+// getIngressRulesFromLabels allows the namespaces with one of labels, the same namespace is only allowed by the
+// allow-from-self policy
 func getIngressRulesFromLabels(labels string) []networkv1.NetworkPolicyIngressRule {
 	labelsStrings := strings.Split(labels, ",")
 	rules := make([]networkv1.NetworkPolicyIngressRule, 0)
-	rules = append(rules, networkv1.NetworkPolicyIngressRule{
-		From: []networkv1.NetworkPolicyPeer{networkv1.NetworkPolicyPeer{
-			PodSelector: &metav1.LabelSelector{},
-		}},
-	})
 	for _, labelString := range labelsStrings {
 		labelMap := map[string]string{}
 		if strings.Index(labelString, "=") < 1 {
@@ -0,0 +1,335 @@
+package namespace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
+	corev1 "k8s.io/api/core/v1"
+	networkv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const quarantineAllow = annotationBase + "/quarantine-allow"
+const quarantineNetworkPolicyName = "quarantine"
+const quarantineSnapshotName = "microsegmentation-quarantine-snapshot"
+
+// quarantineRecorded is written by the operator once the policies of a quarantined namespace are saved, it is not
+// part of the intent
+const quarantineRecorded = annotationBase + "/quarantine-recorded"
+
+// kinds owning the policies generated by the operator controllers
+var generatedPolicyOwners = map[string]bool{
+	"Namespace":   true,
+	"Service":     true,
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"CronJob":     true,
+	"Ingress":     true,
+	"Route":       true,
+}
+
+// reconcileQuarantine replaces every generated policy of namespace with a strict ingress and egress deny. The
+// replaced policies are saved in a snapshot ConfigMap so that lifting the quarantine restores them as they were.
+func (r *ReconcileNamespace) reconcileQuarantine(namespace *corev1.Namespace) error {
+	snapshotName := getSnapshotName(namespace)
+	newlyQuarantined := namespace.Annotations[quarantineRecorded] != "true"
+	snapshot := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshotName.Name,
+			Namespace: snapshotName.Namespace,
+			Labels:    map[string]string{managedLabel: "true"},
+		},
+		Data: map[string]string{},
+	}
+	// the policies saved earlier in this quarantine are gone by now, anything found before it began is stale
+	if !newlyQuarantined {
+		current := &corev1.ConfigMap{}
+		err := r.GetClient().Get(context.TODO(), snapshotName, current)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		for key, value := range current.Data {
+			snapshot.Data[key] = value
+		}
+	}
+
+	generated, err := r.getGeneratedPolicies(namespace)
+	if err != nil {
+		return err
+	}
+	for i := range generated {
+		key := getSnapshotKey(&generated[i])
+		if _, ok := snapshot.Data[key]; ok {
+			continue
+		}
+		data, err := json.Marshal(getSnapshotObject(&generated[i]).Object)
+		if err != nil {
+			return err
+		}
+		snapshot.Data[key] = string(data)
+	}
+	// the snapshot is saved before anything is deleted
+	err = r.CreateOrUpdateResource(namespace, snapshotName.Namespace, snapshot)
+	if err != nil {
+		return err
+	}
+	if newlyQuarantined {
+		if namespace.Annotations == nil {
+			namespace.Annotations = map[string]string{}
+		}
+		namespace.Annotations[quarantineRecorded] = "true"
+		err = r.GetClient().Update(context.TODO(), namespace)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range generated {
+		err = r.GetClient().Delete(context.TODO(), &generated[i])
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	quarantineNetworkPolicy := getQuarantineNetworkPolicy(namespace, r.adminNetworkPolicyConfig)
+	err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, namespace, quarantineNetworkPolicy)
+	if err != nil {
+		return err
+	}
+	err = backend.ReconcileMultiNetworkPolicy(&r.ReconcilerBase, namespace, quarantineNetworkPolicy, backend.GetNetworks(namespace.Annotations[networkAttachmentDefinitions], namespace.GetName()))
+	if err != nil {
+		return err
+	}
+	if newlyQuarantined {
+		r.GetRecorder().Event(namespace, "Warning", "Quarantined", fmt.Sprintf("namespace quarantined, %d policies saved in ConfigMap %s", len(generated), snapshotName))
+	}
+	return nil
+}
+
+// liftQuarantine restores the policies saved when namespace was quarantined and removes the quarantine policy.
+// Only namespaces the operator recorded as quarantined are restored, and only generated policies of namespace.
+func (r *ReconcileNamespace) liftQuarantine(namespace *corev1.Namespace) error {
+	if namespace.Annotations[quarantineRecorded] != "true" {
+		return nil
+	}
+	snapshot := &corev1.ConfigMap{}
+	err := r.GetClient().Get(context.TODO(), getSnapshotName(namespace), snapshot)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	snapshotFound := err == nil
+	restored := 0
+	if snapshotFound {
+		keys := []string{}
+		for key := range snapshot.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			obj := &unstructured.Unstructured{}
+			err = json.Unmarshal([]byte(snapshot.Data[key]), &obj.Object)
+			if err != nil {
+				log.Error(err, "unable to read policy from quarantine snapshot", "key", key)
+				continue
+			}
+			if !r.isRestorable(obj) {
+				log.Info("ignoring object of quarantine snapshot that is not a generated policy", "key", key)
+				continue
+			}
+			obj.SetNamespace(namespace.GetName())
+			err = r.GetClient().Create(context.TODO(), obj)
+			if err != nil && !errors.IsAlreadyExists(err) {
+				return err
+			}
+			restored++
+		}
+	}
+
+	// the restored policies are in place before the quarantine policy goes away
+	quarantineNetworkPolicy := getQuarantineNetworkPolicy(namespace, r.adminNetworkPolicyConfig)
+	err = backend.ReconcileMultiNetworkPolicy(&r.ReconcilerBase, namespace, quarantineNetworkPolicy, nil)
+	if err != nil {
+		return err
+	}
+	err = backend.Delete(r.GetClient(), r.backend, quarantineNetworkPolicy)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if snapshotFound {
+		err = r.GetClient().Delete(context.TODO(), snapshot)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	delete(namespace.Annotations, quarantineRecorded)
+	err = r.GetClient().Update(context.TODO(), namespace)
+	if err != nil {
+		return err
+	}
+	r.GetRecorder().Event(namespace, "Normal", "QuarantineLifted", fmt.Sprintf("namespace quarantine lifted, %d policies restored", restored))
+	return nil
+}
+
+// isRestorable reports whether obj, read from a quarantine snapshot, is a policy the operator generates
+func (r *ReconcileNamespace) isRestorable(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	if gvk != r.backend.GroupVersionKind() && gvk != backend.MultiNetworkPolicyGVK {
+		return false
+	}
+	owner := metav1.GetControllerOf(obj)
+	return owner != nil && generatedPolicyOwners[owner.Kind]
+}
+
+// getSnapshotName locates the quarantine snapshot of namespace, in the operator namespace where tenants cannot
+// write when it is known
+func getSnapshotName(namespace *corev1.Namespace) types.NamespacedName {
+	operatorNamespace := os.Getenv(quarantine.OperatorNamespaceEnv)
+	if operatorNamespace == "" {
+		return types.NamespacedName{Namespace: namespace.GetName(), Name: quarantineSnapshotName}
+	}
+	return types.NamespacedName{Namespace: operatorNamespace, Name: quarantineSnapshotName + "-" + namespace.GetName()}
+}
+
+// getGeneratedPolicies returns the policies and MultiNetworkPolicies in namespace owned by a kind the operator generates policies for
+func (r *ReconcileNamespace) getGeneratedPolicies(namespace *corev1.Namespace) ([]unstructured.Unstructured, error) {
+	generated := []unstructured.Unstructured{}
+	for _, gvk := range []schema.GroupVersionKind{r.backend.GroupVersionKind(), backend.MultiNetworkPolicyGVK} {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := r.GetClient().List(context.TODO(), client.InNamespace(namespace.GetName()), list)
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, item := range list.Items {
+			if item.GetName() == quarantineNetworkPolicyName {
+				continue
+			}
+			owner := metav1.GetControllerOf(&item)
+			if owner != nil && generatedPolicyOwners[owner.Kind] {
+				generated = append(generated, item)
+			}
+		}
+	}
+	return generated, nil
+}
+
+func getSnapshotKey(obj *unstructured.Unstructured) string {
+	return strings.ToLower(obj.GetKind()) + "." + obj.GetName()
+}
+
+// getSnapshotObject keeps what is needed to create obj again
+func getSnapshotObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(obj.GroupVersionKind())
+	snapshot.SetName(obj.GetName())
+	snapshot.SetNamespace(obj.GetNamespace())
+	snapshot.SetLabels(obj.GetLabels())
+	snapshot.SetAnnotations(obj.GetAnnotations())
+	snapshot.SetOwnerReferences(obj.GetOwnerReferences())
+	if spec, ok := obj.Object["spec"]; ok {
+		snapshot.Object["spec"] = spec
+	}
+	return snapshot
+}
+
+// getQuarantineNetworkPolicy denies all ingress and egress of namespace, except DNS and monitoring when kept
+func getQuarantineNetworkPolicy(namespace *corev1.Namespace, config adminNetworkPolicyConfig) *networkv1.NetworkPolicy {
+	networkPolicy := &networkv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      quarantineNetworkPolicyName,
+			Namespace: namespace.GetName(),
+		},
+		Spec: networkv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			Egress:      []networkv1.NetworkPolicyEgressRule{},
+			Ingress:     []networkv1.NetworkPolicyIngressRule{},
+			PolicyTypes: []networkv1.PolicyType{networkv1.PolicyTypeIngress, networkv1.PolicyTypeEgress},
+		},
+	}
+	for _, allowed := range getListFromAnnotation(namespace.Annotations[quarantineAllow]) {
+		switch allowed {
+		case "monitoring":
+			networkPolicy.Spec.Ingress = append(networkPolicy.Spec.Ingress, networkv1.NetworkPolicyIngressRule{
+				From: []networkv1.NetworkPolicyPeer{{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: config.monitoringNamespaceLabels},
+				}},
+			})
+		case "dns":
+			networkPolicy.Spec.Egress = append(networkPolicy.Spec.Egress, networkv1.NetworkPolicyEgressRule{
+				To: []networkv1.NetworkPolicyPeer{{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: config.dnsNamespaceLabels},
+				}},
+				Ports: getNetworkPolicyPorts(getEnv(dnsPortsEnv, defaultDNSPorts)),
+			})
+		default:
+			log.Error(fmt.Errorf("Allowed: %s ", allowed), "check "+quarantineAllow+" annotation - expected dns or monitoring")
+		}
+	}
+	return networkPolicy
+}
+
+func getNetworkPolicyPorts(ports string) []networkv1.NetworkPolicyPort {
+	// this setting looks like this: 53/UDP,53/TCP
+	networkPolicyPorts := []networkv1.NetworkPolicyPort{}
+	for _, portString := range strings.Split(ports, ",") {
+		if strings.Index(portString, "/") < 1 {
+			log.Error(fmt.Errorf("Ports: %s ", ports), "check "+dnsPortsEnv+" - missing / sign ?")
+			continue
+		}
+		intport, err := strconv.Atoi(portString[:strings.Index(portString, "/")])
+		if err != nil {
+			log.Error(err, "unable to convert port to integer", "port", portString)
+			continue
+		}
+		port := intstr.FromInt(intport)
+		protocol := corev1.Protocol(strings.ToUpper(portString[strings.Index(portString, "/")+1:]))
+		networkPolicyPorts = append(networkPolicyPorts, networkv1.NetworkPolicyPort{
+			Port:     &port,
+			Protocol: &protocol,
+		})
+	}
+	return networkPolicyPorts
+}
+
+// quarantineTriggerRequests maps the cluster level quarantine trigger to every namespace
+func quarantineTriggerRequests(c client.Client, namespace string, name string) []reconcile.Request {
+	if !quarantine.IsTrigger(namespace, name) {
+		return []reconcile.Request{}
+	}
+	namespaces := &corev1.NamespaceList{}
+	err := c.List(context.TODO(), &client.ListOptions{}, namespaces)
+	if err != nil {
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, item := range namespaces.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.GetName()}})
+	}
+	return requests
+}
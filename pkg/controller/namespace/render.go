@@ -0,0 +1,27 @@
+package namespace
+
+import (
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
+	corev1 "k8s.io/api/core/v1"
+	networkv1 "k8s.io/api/networking/v1"
+)
+
+// RenderNetworkPolicies returns the NetworkPolicies the controller generates for namespace, before they are
+// rendered by a policy backend, allowing policies excluding quarantined pods. Only the quarantine annotation of the namespace is taken into account, not the
+// cluster level quarantine trigger. Namespaces without the kubernetes.io/metadata.name label are selected by the
+// configured identity label, which the controller adds.
+func RenderNetworkPolicies(namespace *corev1.Namespace) []*networkv1.NetworkPolicy {
+	if namespace.Annotations[quarantine.Annotation] == "true" {
+		return []*networkv1.NetworkPolicy{getQuarantineNetworkPolicy(namespace, getAdminNetworkPolicyConfig())}
+	}
+	networkPolicies := []*networkv1.NetworkPolicy{}
+	if namespace.Annotations[microsgmentationAnnotation] != "true" {
+		return networkPolicies
+	}
+	networkPolicies = append(networkPolicies, getDenyDefaultNetworkPolicy(namespace), backend.ExcludeQuarantinedPods(getNetworkPolicy(namespace)))
+	if namespace.Annotations[allowFromSelfLabel] == "true" {
+		networkPolicies = append(networkPolicies, backend.ExcludeQuarantinedPods(getAllowFromSelfNetworkPolicy(namespace, getIdentityLabel(namespace, getConfiguredIdentityLabel()))))
+	}
+	return networkPolicies
+}
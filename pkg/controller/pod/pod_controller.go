@@ -0,0 +1,203 @@
+package pod
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_pod")
+
+const annotationBase = "microsegmentation-operator.redhat-cop.io"
+
+// quarantineIDLabel identifies a single quarantined pod, pods have no label carrying their name
+const quarantineIDLabel = annotationBase + "/quarantine-id"
+const controllerName = "pod-controller"
+
+// Add creates a new Pod Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	policyBackend, err := backend.FromEnv()
+	if err != nil {
+		return err
+	}
+	return add(mgr, newReconciler(mgr, policyBackend), policyBackend)
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager, policyBackend backend.Backend) reconcile.Reconciler {
+	return &ReconcilePod{
+		ReconcilerBase: util.NewReconcilerBase(mgr.GetClient(), mgr.GetScheme(), mgr.GetConfig(), mgr.GetRecorder(controllerName)),
+		backend:        policyBackend,
+	}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler, policyBackend backend.Backend) error {
+	// Create a new controller
+	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	isQuarantinedPod := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old := e.MetaOld.GetLabels()[quarantine.PodLabel] == "true" || e.MetaOld.GetLabels()[quarantineIDLabel] != ""
+			new := e.MetaNew.GetLabels()[quarantine.PodLabel] == "true" || e.MetaNew.GetLabels()[quarantineIDLabel] != ""
+			return old || new
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			return e.Meta.GetLabels()[quarantine.PodLabel] == "true"
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+	}
+
+	// Watch for changes to primary resource Pod
+	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForObject{}, isQuarantinedPod)
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource rendered policies and requeue the owner Pod
+	err = c.Watch(&source.Kind{Type: policyBackend.ObjectType()}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &corev1.Pod{},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcilePod{}
+
+// ReconcilePod reconciles a Pod object
+type ReconcilePod struct {
+	util.ReconcilerBase
+	backend backend.Backend
+}
+
+// Reconcile reads that state of the cluster for a Pod object and isolates it when it is labelled as quarantined
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcilePod) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling Pod")
+
+	// Fetch the Pod instance
+	instance := &corev1.Pod{}
+	err := r.GetClient().Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	// The object is being deleted
+	if !instance.ObjectMeta.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	networkPolicy := getNetworkPolicy(instance)
+
+	if instance.Labels[quarantine.PodLabel] == "true" {
+		// the policy selects the pod by its quarantine id, set it first
+		if instance.Labels[quarantineIDLabel] != string(instance.GetUID()) {
+			instance.Labels[quarantineIDLabel] = string(instance.GetUID())
+			err = r.GetClient().Update(context.TODO(), instance)
+			if err != nil {
+				log.Error(err, "unable to label quarantined Pod", "Pod", instance.GetName())
+				return r.manageError(err, instance)
+			}
+		}
+		_, err = backend.Get(r.GetClient(), r.backend, networkPolicy.GetNamespace(), networkPolicy.GetName())
+		if err != nil && !errors.IsNotFound(err) {
+			return r.manageError(err, instance)
+		}
+		created := errors.IsNotFound(err)
+		err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, instance, networkPolicy)
+		if err != nil {
+			log.Error(err, "unable to create NetworkPolicy", "NetworkPolicy", networkPolicy)
+			return r.manageError(err, instance)
+		}
+		if created {
+			r.GetRecorder().Event(instance, "Warning", "PodQuarantined", fmt.Sprintf("pod isolated by NetworkPolicy %s", networkPolicy.GetName()))
+		}
+		return reconcile.Result{}, nil
+	}
+
+	err = backend.Delete(r.GetClient(), r.backend, networkPolicy)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "unable to delete NetworkPolicy", "NetworkPolicy", networkPolicy)
+		return r.manageError(err, instance)
+	}
+	if err == nil {
+		r.GetRecorder().Event(instance, "Normal", "PodQuarantineLifted", fmt.Sprintf("NetworkPolicy %s deleted", networkPolicy.GetName()))
+	}
+	if _, ok := instance.Labels[quarantineIDLabel]; ok {
+		delete(instance.Labels, quarantineIDLabel)
+		err = r.GetClient().Update(context.TODO(), instance)
+		if err != nil {
+			log.Error(err, "unable to unlabel Pod", "Pod", instance.GetName())
+			return r.manageError(err, instance)
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// getNetworkPolicy denies all ingress and egress of the pod
+func getNetworkPolicy(pod *corev1.Pod) *networking.NetworkPolicy {
+	return &networking.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "quarantine-pod-" + pod.GetName(),
+			Namespace: pod.GetNamespace(),
+		},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					quarantineIDLabel: string(pod.GetUID()),
+				},
+			},
+			Egress:      []networking.NetworkPolicyEgressRule{},
+			Ingress:     []networking.NetworkPolicyIngressRule{},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress},
+		},
+	}
+}
+
+func (r *ReconcilePod) manageError(issue error, instance runtime.Object) (reconcile.Result, error) {
+	r.GetRecorder().Event(instance, "Warning", "ProcessingError", issue.Error())
+	return reconcile.Result{
+		RequeueAfter: time.Minute * 2,
+		Requeue:      true,
+	}, nil
+}
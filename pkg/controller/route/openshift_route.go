@@ -0,0 +1,79 @@
+package route
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OpenShift Routes are handled as unstructured objects so the operator does not depend on the OpenShift API
+var routeGVK = schema.GroupVersionKind{
+	Group:   "route.openshift.io",
+	Version: "v1",
+	Kind:    "Route",
+}
+
+var openshiftRouteKind = routeKind{
+	name: "route",
+	newObject: func() routeObject {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(routeGVK)
+		return route
+	},
+	list: func(c client.Client, namespace string) ([]routeObject, error) {
+		routeList := &unstructured.UnstructuredList{}
+		routeList.SetGroupVersionKind(routeGVK.GroupVersion().WithKind(routeGVK.Kind + "List"))
+		err := c.List(context.TODO(), client.InNamespace(namespace), routeList)
+		if err != nil {
+			return nil, err
+		}
+		routes := []routeObject{}
+		for i := range routeList.Items {
+			routes = append(routes, &routeList.Items[i])
+		}
+		return routes, nil
+	},
+	backends: func(obj runtime.Object) []serviceBackend {
+		route := obj.(*unstructured.Unstructured)
+		port := getRouteTargetPort(route)
+		backends := []serviceBackend{}
+		if name, _, _ := unstructured.NestedString(route.Object, "spec", "to", "name"); name != "" {
+			backends = append(backends, serviceBackend{service: name, port: port})
+		}
+		alternateBackends, _, _ := unstructured.NestedSlice(route.Object, "spec", "alternateBackends")
+		for _, alternateBackend := range alternateBackends {
+			fields, ok := alternateBackend.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if name, _, _ := unstructured.NestedString(fields, "name"); name != "" {
+				backends = append(backends, serviceBackend{service: name, port: port})
+			}
+		}
+		return backends
+	},
+}
+
+// getRouteTargetPort returns spec.port.targetPort, either a service port name or a target port number
+func getRouteTargetPort(route *unstructured.Unstructured) *intstr.IntOrString {
+	value, ok, _ := unstructured.NestedFieldNoCopy(route.Object, "spec", "port", "targetPort")
+	if !ok {
+		return nil
+	}
+	switch targetPort := value.(type) {
+	case string:
+		port := intstr.FromString(targetPort)
+		return &port
+	case int64:
+		port := intstr.FromInt(int(targetPort))
+		return &port
+	case float64:
+		port := intstr.FromInt(int(targetPort))
+		return &port
+	}
+	return nil
+}
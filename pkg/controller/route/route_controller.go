@@ -0,0 +1,382 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_route")
+
+const annotationBase = "microsegmentation-operator.redhat-cop.io"
+const microsgmentationAnnotation = annotationBase + "/microsegmentation"
+const routeLabel = annotationBase + "/route"
+const controllerName = "route-controller"
+
+// routerNamespaceLabelsEnv holds the labels selecting the namespace the router runs in
+const routerNamespaceLabelsEnv = "ROUTER_NAMESPACE_LABELS"
+const defaultRouterNamespaceLabels = "network.openshift.io/policy-group=ingress"
+
+// routeObject is a namespaced object routing traffic to services
+type routeObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// serviceBackend is a service targeted by a route, with the service port the route points at
+type serviceBackend struct {
+	service string
+	port    *intstr.IntOrString
+}
+
+// routeKind describes a kind of object exposing services through the router
+type routeKind struct {
+	name      string
+	newObject func() routeObject
+	list      func(c client.Client, namespace string) ([]routeObject, error)
+	backends  func(runtime.Object) []serviceBackend
+}
+
+// Add creates a new Route Controller per route kind and adds them to the Manager. The Manager will set fields on the Controllers
+// and Start them when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	policyBackend, err := backend.FromEnv()
+	if err != nil {
+		return err
+	}
+	kinds := []routeKind{}
+	if _, err := mgr.GetRESTMapper().RESTMapping(ingressGVK.GroupKind(), ingressGVK.Version); err == nil {
+		kinds = append(kinds, ingressKind)
+	} else {
+		log.Info("extensions/v1beta1 Ingress API not available, not watching Ingresses")
+	}
+	if _, err := mgr.GetRESTMapper().RESTMapping(routeGVK.GroupKind(), routeGVK.Version); err == nil {
+		kinds = append(kinds, openshiftRouteKind)
+	} else {
+		log.Info("route.openshift.io API not available, not watching Routes")
+	}
+	for _, kind := range kinds {
+		err := add(mgr, newReconciler(mgr, kind, policyBackend), kind, policyBackend)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager, kind routeKind, policyBackend backend.Backend) reconcile.Reconciler {
+	return &ReconcileRoute{
+		ReconcilerBase:        util.NewReconcilerBase(mgr.GetClient(), mgr.GetScheme(), mgr.GetConfig(), mgr.GetRecorder(controllerName)),
+		kind:                  kind,
+		routerNamespaceLabels: getRouterNamespaceLabels(),
+		backend:               policyBackend,
+	}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler, kind routeKind, policyBackend backend.Backend) error {
+	// Create a new controller
+	c, err := controller.New(controllerName+"-"+kind.name, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource
+	err = c.Watch(&source.Kind{Type: kind.newObject()}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to backend Services and requeue the routes pointing at them
+	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return routesForService(mgr.GetClient(), kind, a.Meta.GetNamespace(), a.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource rendered policies and requeue the owner route
+	err = c.Watch(&source.Kind{Type: policyBackend.ObjectType()}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    kind.newObject(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileRoute{}
+
+// ReconcileRoute reconciles a Route or Ingress object
+type ReconcileRoute struct {
+	util.ReconcilerBase
+	kind                  routeKind
+	routerNamespaceLabels map[string]string
+	backend               backend.Backend
+}
+
+// Reconcile reads that state of the cluster for a Route or Ingress object and makes changes based on the state read
+// and the Services it points at
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileRoute) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Kind", r.kind.name, "Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling Route")
+
+	// Fetch the route instance
+	instance := r.kind.newObject()
+	err := r.GetClient().Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	// The object is being deleted
+	if !instance.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	// Quarantined namespaces only keep the quarantine policy, generated policies are restored when it is lifted
+	quarantined, err := quarantine.IsNamespaceQuarantined(r.GetClient(), instance.GetNamespace())
+	if err != nil {
+		log.Error(err, "unable to check namespace quarantine")
+		return r.manageError(err, instance)
+	}
+	if quarantined {
+		reqLogger.Info("namespace is quarantined, skipping")
+		return reconcile.Result{}, nil
+	}
+
+	desired := map[string]bool{}
+	for _, serviceBackend := range r.kind.backends(instance) {
+		service := &corev1.Service{}
+		err = r.GetClient().Get(context.TODO(), types.NamespacedName{Namespace: instance.GetNamespace(), Name: serviceBackend.service}, service)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return r.manageError(err, instance)
+		}
+		if service.Annotations[microsgmentationAnnotation] != "true" || len(service.Spec.Selector) == 0 {
+			continue
+		}
+		ports, err := resolveTargetPorts(service, serviceBackend.port)
+		if err != nil {
+			r.GetRecorder().Event(instance, "Warning", "UnresolvedTargetPort", err.Error())
+			continue
+		}
+		networkPolicy := getNetworkPolicy(r.kind.name, instance, service, ports, r.routerNamespaceLabels)
+		err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, instance, backend.ExcludeQuarantinedPods(networkPolicy))
+		if err != nil {
+			log.Error(err, "unable to create NetworkPolicy", "NetworkPolicy", networkPolicy)
+			return r.manageError(err, instance)
+		}
+		desired[networkPolicy.GetName()] = true
+	}
+
+	// Remove policies for backends the route no longer points at
+	networkPolicies, err := backend.List(r.GetClient(), r.backend, instance.GetNamespace(), map[string]string{routeLabel: r.kind.name + "-" + instance.GetName()})
+	if err != nil {
+		return r.manageError(err, instance)
+	}
+	for i := range networkPolicies {
+		networkPolicy := &networkPolicies[i]
+		if desired[networkPolicy.GetName()] {
+			continue
+		}
+		err = r.GetClient().Delete(context.TODO(), networkPolicy)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "unable to delete NetworkPolicy", "NetworkPolicy", networkPolicy)
+			return r.manageError(err, instance)
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func getNetworkPolicy(kind string, instance metav1.Object, service *corev1.Service, ports []networking.NetworkPolicyPort, routerNamespaceLabels map[string]string) *networking.NetworkPolicy {
+	return &networking.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kind + "-" + instance.GetName() + "-" + service.GetName(),
+			Namespace: instance.GetNamespace(),
+			Labels: map[string]string{
+				routeLabel: kind + "-" + instance.GetName(),
+			},
+		},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: service.Spec.Selector,
+			},
+			Ingress: []networking.NetworkPolicyIngressRule{
+				{
+					From: []networking.NetworkPolicyPeer{networking.NetworkPolicyPeer{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: routerNamespaceLabels,
+						},
+					}},
+					Ports: ports,
+				},
+			},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress},
+		},
+	}
+}
+
+// resolveTargetPorts returns the pod ports behind the service port a route points at, a route
+// without a port reaches every service port
+func resolveTargetPorts(service *corev1.Service, port *intstr.IntOrString) ([]networking.NetworkPolicyPort, error) {
+	networkPolicyPorts := []networking.NetworkPolicyPort{}
+	for _, servicePort := range service.Spec.Ports {
+		if port != nil {
+			if port.Type == intstr.String && port.StrVal != servicePort.Name {
+				continue
+			}
+			if port.Type == intstr.Int && port.IntVal != servicePort.Port && port.IntVal != servicePort.TargetPort.IntVal {
+				continue
+			}
+		}
+		targetPort := servicePort.TargetPort
+		if targetPort.Type == intstr.Int && targetPort.IntVal == 0 {
+			targetPort = intstr.FromInt(int(servicePort.Port))
+		}
+		protocol := servicePort.Protocol
+		networkPolicyPorts = append(networkPolicyPorts, networking.NetworkPolicyPort{
+			Port:     &targetPort,
+			Protocol: &protocol,
+		})
+	}
+	if len(networkPolicyPorts) == 0 {
+		if port == nil {
+			return nil, fmt.Errorf("service %s has no ports", service.GetName())
+		}
+		return nil, fmt.Errorf("port %s not found on service %s", port.String(), service.GetName())
+	}
+	return networkPolicyPorts, nil
+}
+
+// routesForService maps a Service to the routes of kind pointing at it
+func routesForService(c client.Client, kind routeKind, namespace string, name string) []reconcile.Request {
+	requests := []reconcile.Request{}
+	routes, err := kind.list(c, namespace)
+	if err != nil {
+		if !meta.IsNoMatchError(err) {
+			log.Error(err, "unable to list routes", "Kind", kind.name, "Namespace", namespace)
+		}
+		return requests
+	}
+	for _, route := range routes {
+		for _, serviceBackend := range kind.backends(route) {
+			if serviceBackend.service == name {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: route.GetName()}})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+var ingressGVK = schema.GroupVersionKind{
+	Group:   "extensions",
+	Version: "v1beta1",
+	Kind:    "Ingress",
+}
+
+var ingressKind = routeKind{
+	name:      "ingress",
+	newObject: func() routeObject { return &extensionsv1beta1.Ingress{} },
+	list: func(c client.Client, namespace string) ([]routeObject, error) {
+		ingresses := &extensionsv1beta1.IngressList{}
+		err := c.List(context.TODO(), client.InNamespace(namespace), ingresses)
+		if err != nil {
+			return nil, err
+		}
+		routes := []routeObject{}
+		for i := range ingresses.Items {
+			routes = append(routes, &ingresses.Items[i])
+		}
+		return routes, nil
+	},
+	backends: func(obj runtime.Object) []serviceBackend {
+		ingress := obj.(*extensionsv1beta1.Ingress)
+		ingressBackends := []*extensionsv1beta1.IngressBackend{}
+		if ingress.Spec.Backend != nil {
+			ingressBackends = append(ingressBackends, ingress.Spec.Backend)
+		}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for i := range rule.HTTP.Paths {
+				ingressBackends = append(ingressBackends, &rule.HTTP.Paths[i].Backend)
+			}
+		}
+		backends := []serviceBackend{}
+		for _, ingressBackend := range ingressBackends {
+			port := ingressBackend.ServicePort
+			backends = append(backends, serviceBackend{service: ingressBackend.ServiceName, port: &port})
+		}
+		return backends
+	},
+}
+
+func getRouterNamespaceLabels() map[string]string {
+	value, ok := os.LookupEnv(routerNamespaceLabelsEnv)
+	if !ok || value == "" {
+		value = defaultRouterNamespaceLabels
+	}
+	labelMap := map[string]string{}
+	for _, labelString := range strings.Split(value, ",") {
+		if strings.Index(labelString, "=") < 1 {
+			log.Error(fmt.Errorf("Labels: %s ", value), "check "+routerNamespaceLabelsEnv+" - missing = sign ?")
+			continue
+		}
+		labelMap[labelString[:strings.Index(labelString, "=")]] = labelString[strings.Index(labelString, "=")+1:]
+	}
+	return labelMap
+}
+
+func (r *ReconcileRoute) manageError(issue error, instance runtime.Object) (reconcile.Result, error) {
+	r.GetRecorder().Event(instance, "Warning", "ProcessingError", issue.Error())
+	return reconcile.Result{
+		RequeueAfter: time.Minute * 2,
+		Requeue:      true,
+	}, nil
+}
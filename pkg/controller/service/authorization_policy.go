@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const istioAuthorizationPolicy = annotationBase + "/istio-authorization-policy"
+const allowedPaths = annotationBase + "/allowed-paths"
+const allowedMethods = annotationBase + "/allowed-methods"
+
+// Environment variable holding the trust domain of the mesh, used to build service account principals
+const istioTrustDomainEnv = "ISTIO_TRUST_DOMAIN"
+const defaultIstioTrustDomain = "cluster.local"
+
+var authorizationPolicyGVK = schema.GroupVersionKind{
+	Group:   "security.istio.io",
+	Version: "v1beta1",
+	Kind:    "AuthorizationPolicy",
+}
+
+// reconcileAuthorizationPolicy renders the inbound intent of service as an Istio AuthorizationPolicy applying to
+// the pods selected by podSelector. The policy is deleted when the service does not ask for it.
+func (r *ReconcileService) reconcileAuthorizationPolicy(service *corev1.Service, podSelector metav1.LabelSelector) error {
+	if service.Annotations[microsgmentationAnnotation] != "true" || service.Annotations[istioAuthorizationPolicy] != "true" {
+		return r.deleteAuthorizationPolicy(service)
+	}
+
+	principals := []string{}
+	if inboundPodLabels, ok := service.Annotations[inboundPodLabels]; ok {
+		var err error
+		principals, err = r.getPrincipals(service, getLabelSelectorFromAnnotation(inboundPodLabels).MatchLabels)
+		if err != nil {
+			return err
+		}
+	}
+
+	authorizationPolicy := getAuthorizationPolicy(service, podSelector, principals)
+	return backend.CreateOrUpdateGenerated(&r.ReconcilerBase, service, authorizationPolicy)
+}
+
+func (r *ReconcileService) deleteAuthorizationPolicy(service *corev1.Service) error {
+	err := backend.DeleteGenerated(r.GetClient(), authorizationPolicyGVK, service.GetNamespace(), "service-"+service.GetName())
+	if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return err
+	}
+	return nil
+}
+
+// getPrincipals maps the pods of service matching podLabels to the principals of their service accounts. Service
+// accounts other pods of the namespace also run as are left out, their principal would allow those pods too.
+func (r *ReconcileService) getPrincipals(service *corev1.Service, podLabels map[string]string) ([]string, error) {
+	namespace := service.GetNamespace()
+	pods := &corev1.PodList{}
+	err := r.GetClient().List(context.TODO(), client.InNamespace(namespace), pods)
+	if err != nil {
+		return nil, err
+	}
+	trustDomain := os.Getenv(istioTrustDomainEnv)
+	if trustDomain == "" {
+		trustDomain = defaultIstioTrustDomain
+	}
+	selector := labels.SelectorFromSet(podLabels)
+	serviceAccounts := map[string]bool{}
+	for i := range pods.Items {
+		if selector.Matches(labels.Set(pods.Items[i].GetLabels())) {
+			serviceAccounts[getServiceAccountName(&pods.Items[i])] = true
+		}
+	}
+	principals := []string{}
+	for serviceAccount := range serviceAccounts {
+		if pod := findOtherPodOf(pods, serviceAccount, selector); pod != nil {
+			r.GetRecorder().Event(service, "Warning", "AmbiguousServiceAccount", fmt.Sprintf("service account %s is also used by pod %s, not matched by %s, it is not allowed", serviceAccount, pod.GetName(), selector.String()))
+			continue
+		}
+		principals = append(principals, trustDomain+"/ns/"+namespace+"/sa/"+serviceAccount)
+	}
+	sort.Strings(principals)
+	return principals, nil
+}
+
+// findOtherPodOf returns a pod running as serviceAccount that selector does not match
+func findOtherPodOf(pods *corev1.PodList, serviceAccount string, selector labels.Selector) *corev1.Pod {
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if getServiceAccountName(pod) == serviceAccount && !selector.Matches(labels.Set(pod.GetLabels())) {
+			return pod
+		}
+	}
+	return nil
+}
+
+func getAuthorizationPolicy(service *corev1.Service, podSelector metav1.LabelSelector, principals []string) *unstructured.Unstructured {
+	rule := map[string]interface{}{}
+	if _, ok := service.Annotations[inboundPodLabels]; ok {
+		rule["from"] = []interface{}{
+			map[string]interface{}{
+				"source": map[string]interface{}{"principals": toInterfaceSlice(principals)},
+			},
+		}
+	}
+	operation := map[string]interface{}{}
+	if paths := getListFromAnnotation(service.Annotations[allowedPaths]); len(paths) > 0 {
+		operation["paths"] = toInterfaceSlice(paths)
+	}
+	if methods := getListFromAnnotation(strings.ToUpper(service.Annotations[allowedMethods])); len(methods) > 0 {
+		operation["methods"] = toInterfaceSlice(methods)
+	}
+	if len(operation) > 0 {
+		rule["to"] = []interface{}{
+			map[string]interface{}{"operation": operation},
+		}
+	}
+
+	// an ALLOW policy without rules denies everything, an empty principals list would allow any source
+	rules := []interface{}{rule}
+	if _, ok := service.Annotations[inboundPodLabels]; ok && len(principals) == 0 {
+		rules = []interface{}{}
+	}
+
+	matchLabels := map[string]interface{}{}
+	for key, value := range podSelector.MatchLabels {
+		matchLabels[key] = value
+	}
+
+	authorizationPolicy := &unstructured.Unstructured{}
+	authorizationPolicy.SetGroupVersionKind(authorizationPolicyGVK)
+	authorizationPolicy.SetName("service-" + service.GetName())
+	authorizationPolicy.SetNamespace(service.GetNamespace())
+	authorizationPolicy.Object["spec"] = map[string]interface{}{
+		"selector": map[string]interface{}{"matchLabels": matchLabels},
+		"action":   "ALLOW",
+		"rules":    rules,
+	}
+	return authorizationPolicy
+}
+
+// authorizationPolicyRequests maps a pod to the enabled services rendering an AuthorizationPolicy from
+// inbound-pod-labels, the pod service account being one of their principals or making one ambiguous
+func authorizationPolicyRequests(c client.Client, namespace string) []reconcile.Request {
+	services := &corev1.ServiceList{}
+	err := c.List(context.TODO(), client.InNamespace(namespace), services)
+	if err != nil {
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, service := range services.Items {
+		if service.Annotations[microsgmentationAnnotation] != "true" || service.Annotations[istioAuthorizationPolicy] != "true" {
+			continue
+		}
+		if _, ok := service.Annotations[inboundPodLabels]; !ok {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: service.GetName()}})
+	}
+	return requests
+}
+
+func getListFromAnnotation(value string) []string {
+	// this annotation looks like this: value1,value2
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func toInterfaceSlice(values []string) []interface{} {
+	slice := []interface{}{}
+	for _, value := range values {
+		slice = append(slice, value)
+	}
+	return slice
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const serviceNameLabel = "kubernetes.io/service-name"
+
+var endpointSliceGVK = schema.GroupVersionKind{
+	Group:   "discovery.k8s.io",
+	Version: "v1",
+	Kind:    "EndpointSliceList",
+}
+
+// endpointTarget is a single address backing a selector-less service
+type endpointTarget struct {
+	ip      string
+	podName string
+}
+
+// selectorlessServiceRequests maps Endpoints to the Service of the same name when that
+// service is enabled and has no selector, the only case where endpoints drive the policy
+func selectorlessServiceRequests(c client.Client, namespace string, name string) []reconcile.Request {
+	service := &corev1.Service{}
+	err := c.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, service)
+	if err != nil {
+		return []reconcile.Request{}
+	}
+	if service.Annotations[microsgmentationAnnotation] != "true" || len(service.Spec.Selector) != 0 {
+		return []reconcile.Request{}
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+}
+
+// getPodSelectorFromEndpoints derives a pod selector for a selector-less service from its
+// manually managed Endpoints and EndpointSlices. The selector is made of the labels shared by
+// every backing pod and is rejected if it would also select pods not backing the service.
+func (r *ReconcileService) getPodSelectorFromEndpoints(service *corev1.Service) (*metav1.LabelSelector, error) {
+	targets, err := r.getEndpointTargets(service)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("service %s has no selector and no endpoints to derive backing pods from", service.GetName())
+	}
+
+	pods := &corev1.PodList{}
+	err = r.GetClient().List(context.TODO(), client.InNamespace(service.GetNamespace()), pods)
+	if err != nil {
+		return nil, err
+	}
+
+	backingPods := map[string]*corev1.Pod{}
+	for _, target := range targets {
+		pod := findPod(pods, target)
+		if pod == nil {
+			return nil, fmt.Errorf("endpoint address %s of service %s does not belong to a pod in namespace %s", target.ip, service.GetName(), service.GetNamespace())
+		}
+		backingPods[pod.GetName()] = pod
+	}
+
+	var common map[string]string
+	for _, pod := range backingPods {
+		if common == nil {
+			common = map[string]string{}
+			for key, value := range pod.GetLabels() {
+				common[key] = value
+			}
+			continue
+		}
+		for key, value := range common {
+			if pod.GetLabels()[key] != value {
+				delete(common, key)
+			}
+		}
+	}
+	if len(common) == 0 {
+		return nil, fmt.Errorf("pods backing service %s share no common labels", service.GetName())
+	}
+
+	selector := labels.SelectorFromSet(common)
+	for _, pod := range pods.Items {
+		if _, ok := backingPods[pod.GetName()]; !ok && selector.Matches(labels.Set(pod.GetLabels())) {
+			return nil, fmt.Errorf("labels shared by pods backing service %s also select pod %s", service.GetName(), pod.GetName())
+		}
+	}
+
+	return &metav1.LabelSelector{
+		MatchLabels: common,
+	}, nil
+}
+
+func (r *ReconcileService) getEndpointTargets(service *corev1.Service) ([]endpointTarget, error) {
+	targets := []endpointTarget{}
+
+	endpoints := &corev1.Endpoints{}
+	err := r.GetClient().Get(context.TODO(), types.NamespacedName{Namespace: service.GetNamespace(), Name: service.GetName()}, endpoints)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		for _, subset := range endpoints.Subsets {
+			for _, address := range append(subset.Addresses, subset.NotReadyAddresses...) {
+				target := endpointTarget{ip: address.IP}
+				if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+					target.podName = address.TargetRef.Name
+				}
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	// EndpointSlices are read as unstructured objects, clusters without the API are skipped
+	endpointSlices := &unstructured.UnstructuredList{}
+	endpointSlices.SetGroupVersionKind(endpointSliceGVK)
+	err = r.GetClient().List(context.TODO(), client.InNamespace(service.GetNamespace()).MatchingLabels(map[string]string{serviceNameLabel: service.GetName()}), endpointSlices)
+	if err != nil {
+		if meta.IsNoMatchError(err) || errors.IsNotFound(err) {
+			return targets, nil
+		}
+		return nil, err
+	}
+	for _, endpointSlice := range endpointSlices.Items {
+		sliceEndpoints, _, _ := unstructured.NestedSlice(endpointSlice.Object, "endpoints")
+		for _, sliceEndpoint := range sliceEndpoints {
+			fields, ok := sliceEndpoint.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			podName := ""
+			if kind, _, _ := unstructured.NestedString(fields, "targetRef", "kind"); kind == "Pod" {
+				podName, _, _ = unstructured.NestedString(fields, "targetRef", "name")
+			}
+			addresses, _, _ := unstructured.NestedStringSlice(fields, "addresses")
+			for _, address := range addresses {
+				targets = append(targets, endpointTarget{ip: address, podName: podName})
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+func findPod(pods *corev1.PodList, target endpointTarget) *corev1.Pod {
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if target.podName != "" && pod.GetName() == target.podName {
+			return pod
+		}
+		if target.podName == "" && pod.Status.PodIP == target.ip {
+			return pod
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/resolver"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const externalNameEgress = annotationBase + "/external-name-egress"
+const externalNameSourcePodLabels = annotationBase + "/external-name-source-pod-labels"
+const externalNameRefreshInterval = annotationBase + "/external-name-refresh-interval"
+const resolvedAddresses = annotationBase + "/resolved-addresses"
+const defaultExternalNameRefreshInterval = time.Minute * 5
+
+// Environment variable listing the ports pods allowed to reach an external name resolve it on
+const dnsPortsEnv = "DNS_PORTS"
+const defaultDNSPorts = "53/UDP,53/TCP"
+
+// reconcileExternalName renders egress to the resolved addresses of an ExternalName service and
+// requeues itself so that changed DNS answers are picked up
+func (r *ReconcileService) reconcileExternalName(instance *corev1.Service) (reconcile.Result, error) {
+	networkPolicy := &networking.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-" + instance.GetName(),
+			Namespace: instance.GetNamespace(),
+		},
+	}
+
+	if instance.Annotations[microsgmentationAnnotation] != "true" || instance.Annotations[externalNameEgress] != "true" {
+		return r.deleteNetworkPolicy(networkPolicy, instance)
+	}
+
+	// without source labels the policy would isolate the egress of every pod in the namespace
+	if _, ok := instance.Annotations[externalNameSourcePodLabels]; !ok {
+		err := fmt.Errorf("%s is required, egress to %s is not allowed", externalNameSourcePodLabels, instance.Spec.ExternalName)
+		log.Info("refusing to create NetworkPolicy for ExternalName Service", "reason", err.Error())
+		r.GetRecorder().Event(instance, "Warning", "MissingSourcePodLabels", err.Error())
+		return r.deleteNetworkPolicy(networkPolicy, instance)
+	}
+
+	interval := getRefreshInterval(instance)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	defer cancel()
+	ips, err := r.resolver.LookupIP(ctx, instance.Spec.ExternalName)
+	if err != nil {
+		log.Error(err, "unable to resolve external name", "ExternalName", instance.Spec.ExternalName)
+		r.GetRecorder().Event(instance, "Warning", "ExternalNameResolutionFailed", err.Error())
+		return reconcile.Result{
+			RequeueAfter: interval,
+			Requeue:      true,
+		}, nil
+	}
+	cidrs := resolver.CIDRs(ips)
+	if len(cidrs) == 0 {
+		// a policy without egress rules would deny all egress of the source pods
+		r.GetRecorder().Event(instance, "Warning", "ExternalNameUnresolved", fmt.Sprintf("%s resolved to no address, egress to it is not allowed", instance.Spec.ExternalName))
+		result, err := r.deleteNetworkPolicy(networkPolicy, instance)
+		if err != nil || result.Requeue {
+			return result, err
+		}
+		return reconcile.Result{
+			RequeueAfter: interval,
+			Requeue:      true,
+		}, nil
+	}
+
+	networkPolicy = getExternalNameNetworkPolicy(instance, cidrs)
+
+	previous := ""
+	current, err := backend.Get(r.GetClient(), r.backend, networkPolicy.GetNamespace(), networkPolicy.GetName())
+	if err != nil && !errors.IsNotFound(err) {
+		return r.manageError(err, instance)
+	}
+	if current != nil {
+		previous = current.GetAnnotations()[resolvedAddresses]
+	}
+	if previous != networkPolicy.GetAnnotations()[resolvedAddresses] {
+		r.GetRecorder().Event(instance, "Normal", "ExternalNameResolved", fmt.Sprintf("%s resolved to %s", instance.Spec.ExternalName, strings.Join(cidrs, ",")))
+	}
+
+	err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, instance, backend.ExcludeQuarantinedPods(networkPolicy))
+	if err != nil {
+		log.Error(err, "unable to create NetworkPolicy", "NetworkPolicy", networkPolicy)
+		return r.manageError(err, instance)
+	}
+
+	return reconcile.Result{
+		RequeueAfter: interval,
+		Requeue:      true,
+	}, nil
+}
+
+// getExternalNameNetworkPolicy allows the pods selected by the source pod labels of service, which must be set, to
+// resolve names and to reach cidrs, which must not be empty
+func getExternalNameNetworkPolicy(service *corev1.Service, cidrs []string) *networking.NetworkPolicy {
+	podSelector := getLabelSelectorFromAnnotation(service.Annotations[externalNameSourcePodLabels])
+
+	networkPolicy := &networking.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-" + service.GetName(),
+			Namespace: service.GetNamespace(),
+			Annotations: map[string]string{
+				resolvedAddresses: strings.Join(cidrs, ","),
+			},
+		},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: *podSelector,
+			Egress:      []networking.NetworkPolicyEgressRule{},
+			Ingress:     []networking.NetworkPolicyIngressRule{},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeEgress},
+		},
+	}
+
+	// ExternalName services are not proxied, clients connect to the external host on the service port
+	ports := []networking.NetworkPolicyPort{}
+	for _, servicePort := range service.Spec.Ports {
+		port := intstr.FromInt(int(servicePort.Port))
+		protocol := servicePort.Protocol
+		ports = append(ports, networking.NetworkPolicyPort{
+			Port:     &port,
+			Protocol: &protocol,
+		})
+	}
+	ports = append(ports, getPortsFromAnnotation(service.Annotations[outboundPorts])...)
+
+	networkPolicy.Spec.Egress = append(networkPolicy.Spec.Egress, networking.NetworkPolicyEgressRule{
+		To:    getIPBlockPeers(cidrs),
+		Ports: ports,
+	})
+	// the source pods are egress isolated, they still need to resolve names
+	networkPolicy.Spec.Egress = append(networkPolicy.Spec.Egress, networking.NetworkPolicyEgressRule{
+		Ports: getPortsFromAnnotation(getEnv(dnsPortsEnv, defaultDNSPorts)),
+	})
+
+	return networkPolicy
+}
+
+func getEnv(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getRefreshInterval(service *corev1.Service) time.Duration {
+	value, ok := service.Annotations[externalNameRefreshInterval]
+	if !ok {
+		return defaultExternalNameRefreshInterval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		log.Error(err, "unable to parse refresh interval, using default", "interval", value)
+		return defaultExternalNameRefreshInterval
+	}
+	return interval
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/resolver"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newExternalNameService(annotations map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "payments",
+			Namespace:   "shop",
+			Annotations: annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: "api.example.com",
+			Ports:        []corev1.ServicePort{{Port: 443, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+}
+
+func newTestReconciler(t *testing.T, answers map[string][]string) (*ReconcileService, *record.FakeRecorder) {
+	policyBackend, err := backend.New("kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	return &ReconcileService{
+		ReconcilerBase: util.NewReconcilerBase(fake.NewFakeClient(), scheme.Scheme, nil, recorder),
+		resolver:       resolver.NewStubResolver(answers),
+		backend:        policyBackend,
+	}, recorder
+}
+
+// getEventReasons returns the type and reason of the events recorded so far
+func getEventReasons(recorder *record.FakeRecorder) []string {
+	reasons := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			fields := strings.Fields(event)
+			reasons = append(reasons, fields[0]+" "+fields[1])
+		default:
+			return reasons
+		}
+	}
+}
+
+func getGeneratedPolicy(t *testing.T, r *ReconcileService) *networking.NetworkPolicy {
+	networkPolicy := &networking.NetworkPolicy{}
+	err := r.GetClient().Get(context.TODO(), types.NamespacedName{Namespace: "shop", Name: "service-payments"}, networkPolicy)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return networkPolicy
+}
+
+func TestReconcileExternalName(t *testing.T) {
+	r, recorder := newTestReconciler(t, map[string][]string{"api.example.com": {"198.51.100.1", "192.0.2.10"}})
+	service := newExternalNameService(map[string]string{
+		microsgmentationAnnotation:  "true",
+		externalNameEgress:          "true",
+		externalNameSourcePodLabels: "app=checkout",
+	})
+
+	result, err := r.reconcileExternalName(service)
+	if err != nil {
+		t.Fatalf("reconcileExternalName: %v", err)
+	}
+	if result.RequeueAfter != defaultExternalNameRefreshInterval {
+		t.Errorf("requeued after %s, want %s", result.RequeueAfter, defaultExternalNameRefreshInterval)
+	}
+	if reasons := getEventReasons(recorder); !reflect.DeepEqual(reasons, []string{"Normal ExternalNameResolved"}) {
+		t.Errorf("events %v", reasons)
+	}
+
+	networkPolicy := getGeneratedPolicy(t, r)
+	if networkPolicy == nil {
+		t.Fatal("NetworkPolicy not created")
+	}
+	if !reflect.DeepEqual(networkPolicy.Spec.PodSelector.MatchLabels, map[string]string{"app": "checkout"}) {
+		t.Errorf("selects %v, want the source pod labels", networkPolicy.Spec.PodSelector.MatchLabels)
+	}
+	if !reflect.DeepEqual(networkPolicy.Spec.PolicyTypes, []networking.PolicyType{networking.PolicyTypeEgress}) {
+		t.Errorf("policy types %v, want Egress only", networkPolicy.Spec.PolicyTypes)
+	}
+	if len(networkPolicy.Spec.Egress) != 2 {
+		t.Fatalf("%d egress rules, want the external name and DNS rules", len(networkPolicy.Spec.Egress))
+	}
+
+	externalName := networkPolicy.Spec.Egress[0]
+	cidrs := []string{}
+	for _, peer := range externalName.To {
+		cidrs = append(cidrs, peer.IPBlock.CIDR)
+	}
+	if !reflect.DeepEqual(cidrs, []string{"192.0.2.10/32", "198.51.100.1/32"}) {
+		t.Errorf("external name rule allows %v", cidrs)
+	}
+	if got := formatTestPorts(externalName.Ports); got != "443/TCP" {
+		t.Errorf("external name rule allows ports %s, want 443/TCP", got)
+	}
+
+	dns := networkPolicy.Spec.Egress[1]
+	if len(dns.To) != 0 {
+		t.Errorf("DNS rule restricted to %v, want every destination", dns.To)
+	}
+	if got := formatTestPorts(dns.Ports); got != "53/UDP,53/TCP" {
+		t.Errorf("DNS rule allows ports %s, want 53/UDP,53/TCP", got)
+	}
+}
+
+func TestReconcileExternalNameDNSPorts(t *testing.T) {
+	r, _ := newTestReconciler(t, map[string][]string{"api.example.com": {"192.0.2.10"}})
+	service := newExternalNameService(map[string]string{
+		microsgmentationAnnotation:  "true",
+		externalNameEgress:          "true",
+		externalNameSourcePodLabels: "app=checkout",
+	})
+
+	os.Setenv(dnsPortsEnv, "5353/UDP")
+	defer os.Unsetenv(dnsPortsEnv)
+	_, err := r.reconcileExternalName(service)
+	if err != nil {
+		t.Fatalf("reconcileExternalName: %v", err)
+	}
+	networkPolicy := getGeneratedPolicy(t, r)
+	if networkPolicy == nil || len(networkPolicy.Spec.Egress) != 2 {
+		t.Fatalf("NetworkPolicy %v, want the external name and DNS rules", networkPolicy)
+	}
+	if got := formatTestPorts(networkPolicy.Spec.Egress[1].Ports); got != "5353/UDP" {
+		t.Errorf("DNS rule allows ports %s, want the %s ports", got, dnsPortsEnv)
+	}
+}
+
+func TestReconcileExternalNameRefused(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		answers     map[string][]string
+		reason      string
+		requeue     time.Duration
+	}{
+		{
+			name: "source pod labels missing",
+			annotations: map[string]string{
+				microsgmentationAnnotation: "true",
+				externalNameEgress:         "true",
+			},
+			answers: map[string][]string{"api.example.com": {"192.0.2.10"}},
+			reason:  "Warning MissingSourcePodLabels",
+		},
+		{
+			name: "no address",
+			annotations: map[string]string{
+				microsgmentationAnnotation:  "true",
+				externalNameEgress:          "true",
+				externalNameSourcePodLabels: "app=checkout",
+			},
+			answers: map[string][]string{"api.example.com": {}},
+			reason:  "Warning ExternalNameUnresolved",
+			requeue: defaultExternalNameRefreshInterval,
+		},
+		{
+			name: "resolution failure",
+			annotations: map[string]string{
+				microsgmentationAnnotation:  "true",
+				externalNameEgress:          "true",
+				externalNameSourcePodLabels: "app=checkout",
+				externalNameRefreshInterval: "1m",
+			},
+			answers: map[string][]string{},
+			reason:  "Warning ExternalNameResolutionFailed",
+			requeue: time.Minute,
+		},
+	}
+	for _, test := range tests {
+		r, recorder := newTestReconciler(t, test.answers)
+		result, err := r.reconcileExternalName(newExternalNameService(test.annotations))
+		if err != nil {
+			t.Errorf("%s: reconcileExternalName: %v", test.name, err)
+			continue
+		}
+		if result.RequeueAfter != test.requeue {
+			t.Errorf("%s: requeued after %s, want %s", test.name, result.RequeueAfter, test.requeue)
+		}
+		if reasons := getEventReasons(recorder); !reflect.DeepEqual(reasons, []string{test.reason}) {
+			t.Errorf("%s: events %v, want %s", test.name, reasons, test.reason)
+		}
+		if networkPolicy := getGeneratedPolicy(t, r); networkPolicy != nil {
+			t.Errorf("%s: NetworkPolicy created: %v", test.name, networkPolicy.Spec)
+		}
+	}
+}
+
+func formatTestPorts(ports []networking.NetworkPolicyPort) string {
+	formatted := []string{}
+	for _, port := range ports {
+		formatted = append(formatted, port.Port.String()+"/"+string(*port.Protocol))
+	}
+	return strings.Join(formatted, ",")
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ImportNetworkPolicy adds to the annotations of service the settings generating the equivalent of networkPolicy,
+// a policy applying to the pods selected by service. It returns the names of the patterns recognised, or an error
+// describing what the annotations cannot express, in which case service is left unchanged.
+func ImportNetworkPolicy(service *corev1.Service, networkPolicy *networking.NetworkPolicy) ([]string, error) {
+	selector := networkPolicy.Spec.PodSelector
+	if len(service.Spec.Selector) == 0 || len(selector.MatchExpressions) > 0 || !reflect.DeepEqual(selector.MatchLabels, service.Spec.Selector) {
+		return nil, fmt.Errorf("does not select the pods of service %s", service.GetName())
+	}
+	ingress, egress := getPolicyTypes(networkPolicy)
+	if !ingress {
+		return nil, fmt.Errorf("does not restrict ingress, services always restrict ingress to their rules")
+	}
+	if len(networkPolicy.Spec.Ingress) == 0 {
+		return nil, fmt.Errorf("denies all ingress, services always allow their ports")
+	}
+	if egress && len(networkPolicy.Spec.Egress) == 0 {
+		return nil, fmt.Errorf("denies all egress, services only restrict egress to pod labels")
+	}
+
+	annotations := map[string]string{microsgmentationAnnotation: "true"}
+	patterns := []string{}
+	podLabelsRule, allSourcesRule, inClusterRule, sourceRangesRule := false, false, false, false
+	for _, rule := range networkPolicy.Spec.Ingress {
+		switch {
+		case len(rule.From) == 0 || isInClusterPeer(rule.From):
+			// the additional ports of a service without inbound pod labels are open to all sources, or to the
+			// pods of the cluster with source ranges
+			if allSourcesRule || inClusterRule || podLabelsRule {
+				return nil, fmt.Errorf("more than one ingress rule per source")
+			}
+			ports, err := formatPorts(rule.Ports)
+			if err != nil {
+				return nil, err
+			}
+			allSourcesRule, inClusterRule = len(rule.From) == 0, len(rule.From) > 0
+			patterns = append(patterns, "service port rule")
+			if ports != "" {
+				annotations[additionalInboundPortsAnnotation] = ports
+			}
+		case rule.From[0].IPBlock != nil:
+			if sourceRangesRule {
+				return nil, fmt.Errorf("more than one ipBlock ingress rule")
+			}
+			ranges, err := getSourceRangesFromPeers(rule.From)
+			if err != nil {
+				return nil, err
+			}
+			if !hasServicePorts(service, rule.Ports) || len(rule.Ports) != len(service.Spec.Ports) {
+				return nil, fmt.Errorf("ipBlock ingress rule not restricted to the ports of the service")
+			}
+			switch service.Spec.Type {
+			case corev1.ServiceTypeLoadBalancer:
+				if len(service.Spec.LoadBalancerSourceRanges) > 0 {
+					return nil, fmt.Errorf("ipBlock ingress rule on a service with loadBalancerSourceRanges")
+				}
+				annotations[loadBalancerSourceRangesAnnotation] = ranges
+			case corev1.ServiceTypeNodePort:
+				annotations[nodePortSourceRanges] = ranges
+			default:
+				return nil, fmt.Errorf("ipBlock ingress rule on a service that is not a LoadBalancer or NodePort")
+			}
+			sourceRangesRule = true
+			patterns = append(patterns, "source ranges")
+		default:
+			if allSourcesRule || inClusterRule || podLabelsRule {
+				return nil, fmt.Errorf("more than one ingress rule per source")
+			}
+			podLabels, err := getPodLabelsPeer(rule.From)
+			if err != nil {
+				return nil, fmt.Errorf("ingress %s", err.Error())
+			}
+			if len(rule.Ports) == 0 {
+				return nil, fmt.Errorf("ingress rule allowing every port, services restrict inbound pod labels to ports")
+			}
+			if !hasServicePorts(service, rule.Ports) {
+				return nil, fmt.Errorf("ingress rule not allowing every port of the service")
+			}
+			additional, err := formatPorts(getAdditionalPorts(service, rule.Ports))
+			if err != nil {
+				return nil, err
+			}
+			podLabelsRule = true
+			patterns = append(patterns, "service port rule")
+			annotations[inboundPodLabels] = podLabels
+			if additional != "" {
+				annotations[additionalInboundPortsAnnotation] = additional
+			}
+		}
+	}
+	// with source ranges, a service without inbound pod labels only allows the pods of the cluster besides them
+	sourceRanges := sourceRangesRule || len(getSourceRanges(service)) > 0
+	switch {
+	case sourceRanges && allSourcesRule:
+		return nil, fmt.Errorf("ingress rule allowing every source on a service with source ranges")
+	case sourceRangesRule && !podLabelsRule && !inClusterRule:
+		return nil, fmt.Errorf("ipBlock ingress rule without a rule for the pods of the cluster")
+	case inClusterRule && !sourceRanges:
+		return nil, fmt.Errorf("ingress rule allowing every namespace on a service without source ranges")
+	}
+	if len(networkPolicy.Spec.Egress) > 1 {
+		return nil, fmt.Errorf("more than one egress rule")
+	}
+	for _, rule := range networkPolicy.Spec.Egress {
+		podLabels, err := getPodLabelsPeer(rule.To)
+		if err != nil {
+			return nil, fmt.Errorf("egress %s", err.Error())
+		}
+		ports, err := formatPorts(rule.Ports)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, "pod-label egress")
+		annotations[outboundPodLabels] = podLabels
+		if ports != "" {
+			annotations[outboundPorts] = ports
+		}
+	}
+
+	for key, value := range annotations {
+		if current, ok := service.Annotations[key]; ok && current != value && key != microsgmentationAnnotation {
+			return nil, fmt.Errorf("conflicts with %s=%s already imported", key, current)
+		}
+	}
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	for key, value := range annotations {
+		service.Annotations[key] = value
+	}
+	return patterns, nil
+}
+
+// getPodLabelsPeer returns the label1=value1,label2=value2 of a single peer selecting pods of the same namespace
+func getPodLabelsPeer(peers []networking.NetworkPolicyPeer) (string, error) {
+	if len(peers) != 1 {
+		return "", fmt.Errorf("rule with more than one peer")
+	}
+	peer := peers[0]
+	switch {
+	case peer.NamespaceSelector != nil:
+		return "", fmt.Errorf("rule selecting pods of other namespaces")
+	case peer.PodSelector == nil || len(peer.PodSelector.MatchLabels) == 0:
+		return "", fmt.Errorf("rule selecting every pod of the namespace")
+	case len(peer.PodSelector.MatchExpressions) > 0:
+		return "", fmt.Errorf("rule with a pod selector expression")
+	}
+	labels := []string{}
+	for key, value := range peer.PodSelector.MatchLabels {
+		if strings.Contains(value, ",") {
+			return "", fmt.Errorf("rule with a pod label value containing a comma")
+		}
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ","), nil
+}
+
+// isInClusterPeer reports whether peers is the single peer selecting every pod of every namespace
+func isInClusterPeer(peers []networking.NetworkPolicyPeer) bool {
+	if len(peers) != 1 || peers[0].NamespaceSelector == nil || peers[0].PodSelector != nil || peers[0].IPBlock != nil {
+		return false
+	}
+	return len(peers[0].NamespaceSelector.MatchLabels) == 0 && len(peers[0].NamespaceSelector.MatchExpressions) == 0
+}
+
+// getSourceRangesFromPeers returns the comma separated CIDRs of ipBlock peers without exceptions
+func getSourceRangesFromPeers(peers []networking.NetworkPolicyPeer) (string, error) {
+	cidrs := []string{}
+	for _, peer := range peers {
+		if peer.IPBlock == nil || peer.PodSelector != nil || peer.NamespaceSelector != nil {
+			return "", fmt.Errorf("ingress rule mixing ipBlock and selector peers")
+		}
+		if len(peer.IPBlock.Except) > 0 {
+			return "", fmt.Errorf("ipBlock peer with exceptions")
+		}
+		cidrs = append(cidrs, peer.IPBlock.CIDR)
+	}
+	return strings.Join(cidrs, ","), nil
+}
+
+// getServiceTargetPorts returns the target ports of service, an unset target port being the service port
+func getServiceTargetPorts(service *corev1.Service) []networking.NetworkPolicyPort {
+	servicePorts := []corev1.ServicePort{}
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntVal == 0 {
+			servicePort.TargetPort = intstr.FromInt(int(servicePort.Port))
+		}
+		servicePorts = append(servicePorts, servicePort)
+	}
+	return getPortsFromService(servicePorts)
+}
+
+// hasServicePorts reports whether ports include every target port of service
+func hasServicePorts(service *corev1.Service, ports []networking.NetworkPolicyPort) bool {
+	for _, servicePort := range getServiceTargetPorts(service) {
+		found := false
+		for _, port := range ports {
+			if isSamePort(servicePort, port) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// getAdditionalPorts returns the ports that are not target ports of service
+func getAdditionalPorts(service *corev1.Service, ports []networking.NetworkPolicyPort) []networking.NetworkPolicyPort {
+	additional := []networking.NetworkPolicyPort{}
+	for _, port := range ports {
+		found := false
+		for _, servicePort := range getServiceTargetPorts(service) {
+			if isSamePort(servicePort, port) {
+				found = true
+			}
+		}
+		if !found {
+			additional = append(additional, port)
+		}
+	}
+	return additional
+}
+
+func isSamePort(a networking.NetworkPolicyPort, b networking.NetworkPolicyPort) bool {
+	return getPortProtocol(a) == getPortProtocol(b) && a.Port != nil && b.Port != nil && *a.Port == *b.Port
+}
+
+func getPortProtocol(port networking.NetworkPolicyPort) corev1.Protocol {
+	if port.Protocol == nil || *port.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return *port.Protocol
+}
+
+// formatPorts returns ports in the 9999/TCP,8888/UDP format of the port annotations
+func formatPorts(ports []networking.NetworkPolicyPort) (string, error) {
+	formatted := []string{}
+	for _, port := range ports {
+		if port.Port == nil {
+			return "", fmt.Errorf("rule allowing every port of a protocol")
+		}
+		if port.Port.Type != intstr.Int {
+			return "", fmt.Errorf("rule with named port %s outside the service ports", port.Port.StrVal)
+		}
+		formatted = append(formatted, fmt.Sprintf("%d/%s", port.Port.IntVal, getPortProtocol(port)))
+	}
+	return strings.Join(formatted, ","), nil
+}
+
+// getPolicyTypes returns the policy types of networkPolicy, defaulted the way the API server does
+func getPolicyTypes(networkPolicy *networking.NetworkPolicy) (bool, bool) {
+	if len(networkPolicy.Spec.PolicyTypes) == 0 {
+		return true, len(networkPolicy.Spec.Egress) > 0
+	}
+	ingress, egress := false, false
+	for _, policyType := range networkPolicy.Spec.PolicyTypes {
+		switch policyType {
+		case networking.PolicyTypeIngress:
+			ingress = true
+		case networking.PolicyTypeEgress:
+			egress = true
+		}
+	}
+	return ingress, egress
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+)
+
+// RenderNetworkPolicies returns the NetworkPolicies the controller generates for service at now, before they are
+// rendered by a policy backend, excluding quarantined pods. Cluster state is not available: inbound service accounts are not resolved, and
+// ExternalName services, having no resolved addresses, and selector-less services, whose pods are only known from
+// their endpoints, generate nothing.
+func RenderNetworkPolicies(service *corev1.Service, now time.Time) []*networking.NetworkPolicy {
+	networkPolicies := []*networking.NetworkPolicy{}
+	if service.Annotations[microsgmentationAnnotation] != "true" {
+		return networkPolicies
+	}
+
+	if service.Spec.Type == corev1.ServiceTypeExternalName || len(service.Spec.Selector) == 0 {
+		return networkPolicies
+	}
+
+	networkPolicy := getNetworkPolicy(service)
+	open := true
+	if _, ok := service.Annotations[scheduleAnnotation]; ok {
+		open, _, _ = getScheduleWindow(service, now)
+	}
+	if open {
+		networkPolicies = append(networkPolicies, backend.ExcludeQuarantinedPods(networkPolicy))
+	}
+
+	if podLabels, ok := service.Annotations[temporaryAccessPodLabels]; ok {
+		expiry, err := getTemporaryAccessExpiry(service)
+		if err == nil && expiry.After(now) {
+			networkPolicies = append(networkPolicies, backend.ExcludeQuarantinedPods(getTemporaryAccessNetworkPolicy(service, networkPolicy.Spec.PodSelector, getLabelSelectorFromAnnotation(podLabels))))
+		}
+	}
+	return networkPolicies
+}
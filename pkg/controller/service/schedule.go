@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/schedule"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const scheduleAnnotation = annotationBase + "/schedule"
+const scheduleDuration = annotationBase + "/schedule-duration"
+
+// nextScheduleTransition is written by the operator, it is not part of the intent
+const nextScheduleTransition = annotationBase + "/next-schedule-transition"
+
+// getScheduleWindow reports whether the schedule window of service is open and when that changes
+func getScheduleWindow(service *corev1.Service, now time.Time) (bool, time.Time, error) {
+	cron, err := schedule.Parse(service.Annotations[scheduleAnnotation])
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	duration, err := time.ParseDuration(service.Annotations[scheduleDuration])
+	if err != nil || duration <= 0 {
+		return false, time.Time{}, fmt.Errorf("%s must be a positive duration, found %q", scheduleDuration, service.Annotations[scheduleDuration])
+	}
+	open, transition := cron.Window(now, duration)
+	return open, transition, nil
+}
+
+// recordScheduleTransition stores the next transition of the schedule window on service and emits an event when
+// the window opened or closed since it was last recorded
+func (r *ReconcileService) recordScheduleTransition(service *corev1.Service, open bool, transition time.Time) error {
+	state := "closes"
+	if !open {
+		state = "opens"
+	}
+	value := "never"
+	if !transition.IsZero() {
+		value = fmt.Sprintf("%s %s", state, transition.UTC().Format(time.RFC3339))
+	}
+	if service.Annotations[nextScheduleTransition] == value {
+		return nil
+	}
+	if open {
+		r.GetRecorder().Event(service, "Normal", "ScheduleWindowOpened", fmt.Sprintf("schedule window open, %s", value))
+	} else {
+		r.GetRecorder().Event(service, "Normal", "ScheduleWindowClosed", fmt.Sprintf("schedule window closed, %s", value))
+	}
+	service.Annotations[nextScheduleTransition] = value
+	return r.GetClient().Update(context.TODO(), service)
+}
+
+// clearScheduleTransition removes the next transition of a service that is no longer scheduled
+func (r *ReconcileService) clearScheduleTransition(service *corev1.Service) error {
+	if _, ok := service.Annotations[nextScheduleTransition]; !ok {
+		return nil
+	}
+	delete(service.Annotations, nextScheduleTransition)
+	return r.GetClient().Update(context.TODO(), service)
+}
+
+// closeScheduleWindow deletes the policies of service allowing traffic, temporary access grants are not affected
+func (r *ReconcileService) closeScheduleWindow(networkPolicy *networking.NetworkPolicy, service *corev1.Service) error {
+	err := backend.ReconcileMultiNetworkPolicy(&r.ReconcilerBase, service, networkPolicy, nil)
+	if err != nil {
+		return err
+	}
+	err = r.deleteAuthorizationPolicy(service)
+	if err != nil {
+		return err
+	}
+	err = backend.Delete(r.GetClient(), r.backend, networkPolicy)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const inboundServiceAccounts = annotationBase + "/inbound-service-accounts"
+
+// labels that change with every rollout and do not identify a workload
+var volatilePodLabels = []string{"pod-template-hash", "controller-revision-hash"}
+
+// getServiceAccountPeers returns the peers identifying the pods running as the inbound service accounts of service.
+// Backends with native service account selectors match the service accounts directly, otherwise the labels of
+// the pods currently running as those service accounts are used, as long as they select no other pod.
+func (r *ReconcileService) getServiceAccountPeers(service *corev1.Service) ([]networking.NetworkPolicyPeer, error) {
+	serviceAccounts := getListFromAnnotation(service.Annotations[inboundServiceAccounts])
+	if len(serviceAccounts) == 0 {
+		return []networking.NetworkPolicyPeer{}, nil
+	}
+
+	if r.backend.SupportsServiceAccountSelectors() {
+		return []networking.NetworkPolicyPeer{{
+			PodSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      backend.ServiceAccountLabel,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   serviceAccounts,
+				}},
+			},
+		}}, nil
+	}
+
+	pods := &corev1.PodList{}
+	err := r.GetClient().List(context.TODO(), client.InNamespace(service.GetNamespace()), pods)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := map[string]bool{}
+	for _, serviceAccount := range serviceAccounts {
+		allowed[serviceAccount] = true
+	}
+
+	selectors := map[string]map[string]string{}
+	for _, pod := range pods.Items {
+		if !allowed[getServiceAccountName(&pod)] {
+			continue
+		}
+		podLabels := map[string]string{}
+		for key, value := range pod.GetLabels() {
+			podLabels[key] = value
+		}
+		for _, label := range volatilePodLabels {
+			delete(podLabels, label)
+		}
+		if len(podLabels) == 0 {
+			r.GetRecorder().Event(service, "Warning", "AmbiguousServiceAccount", fmt.Sprintf("pod %s runs as service account %s but has no labels to select it by", pod.GetName(), getServiceAccountName(&pod)))
+			continue
+		}
+		selectors[labels.Set(podLabels).String()] = podLabels
+	}
+
+	keys := []string{}
+	for key := range selectors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	peers := []networking.NetworkPolicyPeer{}
+	for _, key := range keys {
+		selector := labels.SelectorFromSet(selectors[key])
+		if pod := findOtherPod(pods, selector, allowed); pod != nil {
+			r.GetRecorder().Event(service, "Warning", "AmbiguousServiceAccount", fmt.Sprintf("labels %s also select pod %s running as service account %s", key, pod.GetName(), getServiceAccountName(pod)))
+			continue
+		}
+		peers = append(peers, networking.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: selectors[key],
+			},
+		})
+	}
+	return peers, nil
+}
+
+// findOtherPod returns a pod matched by selector that does not run as an allowed service account
+func findOtherPod(pods *corev1.PodList, selector labels.Selector, allowed map[string]bool) *corev1.Pod {
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !allowed[getServiceAccountName(pod)] && selector.Matches(labels.Set(pod.GetLabels())) {
+			return pod
+		}
+	}
+	return nil
+}
+
+func getServiceAccountName(pod *corev1.Pod) string {
+	if pod.Spec.ServiceAccountName == "" {
+		return "default"
+	}
+	return pod.Spec.ServiceAccountName
+}
+
+// serviceAccountRequests maps a pod to the enabled services whose inbound-service-accounts are resolved from
+// pod labels, which are affected by any pod in the namespace
+func serviceAccountRequests(c client.Client, namespace string) []reconcile.Request {
+	services := &corev1.ServiceList{}
+	err := c.List(context.TODO(), client.InNamespace(namespace), services)
+	if err != nil {
+		return []reconcile.Request{}
+	}
+	requests := []reconcile.Request{}
+	for _, service := range services.Items {
+		if service.Annotations[microsgmentationAnnotation] != "true" || strings.TrimSpace(service.Annotations[inboundServiceAccounts]) == "" {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: service.GetName()}})
+	}
+	return requests
+}
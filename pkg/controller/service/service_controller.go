@@ -3,6 +3,8 @@ package service
 import (
 	"context"
 	"fmt"
+	"net"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -10,11 +12,15 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
+	"github.com/eformat/microsegmentation-operator/pkg/resolver"
 	"github.com/redhat-cop/operator-utils/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -33,23 +39,32 @@ const additionalInboundPortsAnnotation = annotationBase + "/additional-inbound-p
 const inboundPodLabels = annotationBase + "/inbound-pod-labels"
 const outboundPodLabels = annotationBase + "/outbound-pod-labels"
 const outboundPorts = annotationBase + "/outbound-ports"
+const nodePortSourceRanges = annotationBase + "/nodeport-source-ranges"
+const networkAttachmentDefinitions = annotationBase + "/network-attachment-definitions"
+const loadBalancerSourceRangesAnnotation = "service.beta.kubernetes.io/load-balancer-source-ranges"
 const controllerName = "service-controller"
 
 // Add creates a new Service Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	policyBackend, err := backend.FromEnv()
+	if err != nil {
+		return err
+	}
+	return add(mgr, newReconciler(mgr, policyBackend), policyBackend)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager, policyBackend backend.Backend) reconcile.Reconciler {
 	return &ReconcileService{
 		ReconcilerBase: util.NewReconcilerBase(mgr.GetClient(), mgr.GetScheme(), mgr.GetConfig(), mgr.GetRecorder(controllerName)),
+		resolver:       resolver.NewResolver(),
+		backend:        policyBackend,
 	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+func add(mgr manager.Manager, r reconcile.Reconciler, policyBackend backend.Backend) error {
 	// Create a new controller
 	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: r})
 	if err != nil {
@@ -70,7 +85,10 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 			newValue, _ := e.MetaNew.GetAnnotations()[microsgmentationAnnotation]
 			old := oldValue == "true"
 			new := newValue == "true"
-			return old != new
+			if old != new {
+				return true
+			}
+			return new && serviceChanged(e.ObjectOld.(*corev1.Service), e.ObjectNew.(*corev1.Service))
 		},
 		CreateFunc: func(e event.CreateEvent) bool {
 			_, ok := e.Object.(*corev1.Service)
@@ -88,8 +106,18 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	// Watch for changes to secondary resource Pods and requeue the owner Service
-	err = c.Watch(&source.Kind{Type: &networking.NetworkPolicy{}}, &handler.EnqueueRequestForOwner{
+	// Watch for changes to Endpoints of selector-less services and requeue the Service
+	err = c.Watch(&source.Kind{Type: &corev1.Endpoints{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return selectorlessServiceRequests(mgr.GetClient(), a.Meta.GetNamespace(), a.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource rendered policies and requeue the owner Service
+	err = c.Watch(&source.Kind{Type: policyBackend.ObjectType()}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &corev1.Service{},
 	})
@@ -97,6 +125,36 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to Pods, their service accounts are the principals of the AuthorizationPolicies
+	// and, without native service account selectors, their labels identify the inbound service accounts
+	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			requests := authorizationPolicyRequests(mgr.GetClient(), a.Meta.GetNamespace())
+			if !policyBackend.SupportsServiceAccountSelectors() {
+				requests = append(requests, serviceAccountRequests(mgr.GetClient(), a.Meta.GetNamespace())...)
+			}
+			return requests
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to AuthorizationPolicies when Istio is installed and requeue the owner Service
+	if _, err := mgr.GetRESTMapper().RESTMapping(authorizationPolicyGVK.GroupKind(), authorizationPolicyGVK.Version); err == nil {
+		authorizationPolicy := &unstructured.Unstructured{}
+		authorizationPolicy.SetGroupVersionKind(authorizationPolicyGVK)
+		err = c.Watch(&source.Kind{Type: authorizationPolicy}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &corev1.Service{},
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Info("security.istio.io API not available, not watching AuthorizationPolicies")
+	}
+
 	return nil
 }
 
@@ -105,6 +163,8 @@ var _ reconcile.Reconciler = &ReconcileService{}
 // ReconcileService reconciles a Service object
 type ReconcileService struct {
 	util.ReconcilerBase
+	resolver resolver.Resolver
+	backend  backend.Backend
 }
 
 // Reconcile reads that state of the cluster for a Service object and makes changes based on the state read
@@ -134,29 +194,152 @@ func (r *ReconcileService) Reconcile(request reconcile.Request) (reconcile.Resul
 		return reconcile.Result{}, nil
 	}
 
+	// Quarantined namespaces only keep the quarantine policy, generated policies are restored when it is lifted
+	quarantined, err := quarantine.IsNamespaceQuarantined(r.GetClient(), instance.GetNamespace())
+	if err != nil {
+		log.Error(err, "unable to check namespace quarantine")
+		return r.manageError(err, instance)
+	}
+	if quarantined {
+		reqLogger.Info("namespace is quarantined, skipping")
+		return reconcile.Result{}, nil
+	}
+
+	if instance.Spec.Type == corev1.ServiceTypeExternalName {
+		return r.reconcileExternalName(instance)
+	}
+
 	// Define a new Pod object
 	networkPolicy := getNetworkPolicy(instance)
 
 	if instance.Annotations[microsgmentationAnnotation] == "true" {
-		err = r.CreateOrUpdateResource(instance, instance.GetNamespace(), networkPolicy)
-		if err != nil {
-			log.Error(err, "unable to create NetworkPolicy", "NetworkPolicy", networkPolicy)
-			return r.manageError(err, instance)
+		if invalid := getInvalidCIDRs(getSourceRanges(instance)); len(invalid) > 0 {
+			r.GetRecorder().Event(instance, "Warning", "InvalidSourceRanges", fmt.Sprintf("ignoring invalid source ranges %s", strings.Join(invalid, ",")))
 		}
-	} else {
-		err = r.GetClient().Delete(context.TODO(), networkPolicy)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return reconcile.Result{}, nil
+		if len(instance.Spec.Selector) == 0 {
+			// without a selector the policy would select every pod in the namespace
+			podSelector, err := r.getPodSelectorFromEndpoints(instance)
+			if err != nil {
+				log.Info("refusing to create NetworkPolicy for selector-less Service", "reason", err.Error())
+				r.GetRecorder().Event(instance, "Warning", "SelectorlessService", err.Error())
+				return r.deleteNetworkPolicy(networkPolicy, instance)
+			}
+			networkPolicy.Spec.PodSelector = *podSelector
+		}
+		if _, ok := instance.Annotations[inboundServiceAccounts]; ok {
+			// peers are identified by the service accounts their pods run as
+			peers, err := r.getServiceAccountPeers(instance)
+			if err != nil {
+				log.Error(err, "unable to resolve inbound service accounts", "Service", instance.GetName())
+				return r.manageError(err, instance)
+			}
+			if len(peers) > 0 {
+				networkPolicy.Spec.Ingress = append(networkPolicy.Spec.Ingress, networking.NetworkPolicyIngressRule{
+					From:  peers,
+					Ports: append(getPortsFromService(instance.Spec.Ports), getPortsFromAnnotation(instance.Annotations[additionalInboundPortsAnnotation])...),
+				})
+			} else {
+				// a rule without peers would allow every source, the service accounts are not allowed instead
+				r.GetRecorder().Event(instance, "Warning", "ServiceAccountsUnresolved", fmt.Sprintf("no pod running as %s can be selected, inbound traffic from them is not allowed", instance.Annotations[inboundServiceAccounts]))
+			}
+		}
+		// Scheduled services only allow traffic while their window is open
+		open := true
+		requeueAfter := time.Duration(0)
+		if _, ok := instance.Annotations[scheduleAnnotation]; ok {
+			var transition time.Time
+			open, transition, err = getScheduleWindow(instance, time.Now())
+			if err != nil {
+				log.Error(err, "unable to parse schedule", "Service", instance.GetName())
+				r.GetRecorder().Event(instance, "Warning", "InvalidSchedule", err.Error())
+			}
+			err = r.recordScheduleTransition(instance, open, transition)
+			if err != nil {
+				return r.manageError(err, instance)
+			}
+			if !transition.IsZero() {
+				requeueAfter = time.Until(transition)
+			}
+		} else {
+			err = r.clearScheduleTransition(instance)
+			if err != nil {
+				return r.manageError(err, instance)
+			}
+		}
+		if open {
+			err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, instance, backend.ExcludeQuarantinedPods(networkPolicy))
+			if err != nil {
+				log.Error(err, "unable to create NetworkPolicy", "NetworkPolicy", networkPolicy)
+				return r.manageError(err, instance)
 			}
-			log.Error(err, "unable to delete NetworkPolicy", "NetworkPolicy", networkPolicy)
+			// Mirror the policy on the secondary networks of the selected pods
+			err = backend.ReconcileMultiNetworkPolicy(&r.ReconcilerBase, instance, backend.ExcludeQuarantinedPods(networkPolicy), backend.GetNetworks(instance.Annotations[networkAttachmentDefinitions], instance.GetNamespace()))
+			if err != nil {
+				log.Error(err, "unable to reconcile MultiNetworkPolicy", "NetworkPolicy", networkPolicy)
+				return r.manageError(err, instance)
+			}
+			// Mirror the inbound intent at L7 for meshed services
+			err = r.reconcileAuthorizationPolicy(instance, networkPolicy.Spec.PodSelector)
+			if err != nil {
+				log.Error(err, "unable to reconcile AuthorizationPolicy", "Service", instance.GetName())
+				return r.manageError(err, instance)
+			}
+		} else {
+			err = r.closeScheduleWindow(networkPolicy, instance)
+			if err != nil {
+				log.Error(err, "unable to delete scheduled NetworkPolicy", "NetworkPolicy", networkPolicy)
+				return r.manageError(err, instance)
+			}
+		}
+		// Temporary access grants are revoked when they expire
+		remaining, err := r.reconcileTemporaryAccess(instance, networkPolicy.Spec.PodSelector)
+		if err != nil {
+			log.Error(err, "unable to reconcile temporary access", "Service", instance.GetName())
 			return r.manageError(err, instance)
 		}
+		if remaining > 0 && (requeueAfter <= 0 || remaining < requeueAfter) {
+			requeueAfter = remaining
+		}
+		if requeueAfter > 0 {
+			return reconcile.Result{
+				RequeueAfter: requeueAfter,
+				Requeue:      true,
+			}, nil
+		}
+	} else {
+		return r.deleteNetworkPolicy(networkPolicy, instance)
 	}
 
 	return reconcile.Result{}, nil
 }
 
+func (r *ReconcileService) deleteNetworkPolicy(networkPolicy *networking.NetworkPolicy, instance *corev1.Service) (reconcile.Result, error) {
+	err := backend.ReconcileMultiNetworkPolicy(&r.ReconcilerBase, instance, networkPolicy, nil)
+	if err != nil {
+		log.Error(err, "unable to delete MultiNetworkPolicy", "NetworkPolicy", networkPolicy)
+		return r.manageError(err, instance)
+	}
+	err = r.deleteAuthorizationPolicy(instance)
+	if err != nil {
+		log.Error(err, "unable to delete AuthorizationPolicy", "Service", instance.GetName())
+		return r.manageError(err, instance)
+	}
+	err = r.revokeTemporaryAccess(instance)
+	if err != nil {
+		log.Error(err, "unable to revoke temporary access", "Service", instance.GetName())
+		return r.manageError(err, instance)
+	}
+	err = backend.Delete(r.GetClient(), r.backend, networkPolicy)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		log.Error(err, "unable to delete NetworkPolicy", "NetworkPolicy", networkPolicy)
+		return r.manageError(err, instance)
+	}
+	return reconcile.Result{}, nil
+}
+
 func getNetworkPolicy(service *corev1.Service) *networking.NetworkPolicy {
 	networkPolicy := &networking.NetworkPolicy{
 		TypeMeta: metav1.TypeMeta{
@@ -186,10 +369,27 @@ func getNetworkPolicy(service *corev1.Service) *networking.NetworkPolicy {
 		}
 		networkPolicy.Spec.Ingress = append(networkPolicy.Spec.Ingress, networkPolicyIngressRule)
 
-	} else { // just append annotation ports, no pod selector
+	} else if _, ok := service.Annotations[inboundServiceAccounts]; !ok { // just append annotation ports, no pod selector
+		// the service accounts rule, added once they are resolved, carries the annotation ports otherwise
 		networkPolicyIngressRule := networking.NetworkPolicyIngressRule{
 			Ports: append([]networking.NetworkPolicyPort{}, getPortsFromAnnotation(service.Annotations[additionalInboundPortsAnnotation])...),
 		}
+		// with source ranges, sources outside the cluster are only allowed by the ipBlock rule below
+		if len(getSourceRanges(service)) > 0 {
+			networkPolicyIngressRule.From = []networking.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{},
+			}}
+		}
+		networkPolicy.Spec.Ingress = append(networkPolicy.Spec.Ingress, networkPolicyIngressRule)
+	}
+
+	// Only the listed source ranges may reach the service ports from outside the cluster. A rule without peers
+	// would allow every source, so none is added when no range is valid.
+	if peers := getIPBlockPeers(getSourceRanges(service)); len(peers) > 0 {
+		networkPolicyIngressRule := networking.NetworkPolicyIngressRule{
+			From:  peers,
+			Ports: getPortsFromService(service.Spec.Ports),
+		}
 		networkPolicy.Spec.Ingress = append(networkPolicy.Spec.Ingress, networkPolicyIngressRule)
 	}
 
@@ -206,6 +406,82 @@ func getNetworkPolicy(service *corev1.Service) *networking.NetworkPolicy {
 	return networkPolicy
 }
 
+// serviceChanged reports annotation and spec changes of an enabled service that affect the generated policy
+func serviceChanged(old *corev1.Service, new *corev1.Service) bool {
+	// selector-less services derive their pod selector from endpoints, so adding
+	// or removing the selector changes the generated policy
+	if !reflect.DeepEqual(old.Spec.Selector, new.Spec.Selector) {
+		return true
+	}
+	if !reflect.DeepEqual(getAnnotations(old), getAnnotations(new)) {
+		return true
+	}
+	if old.Spec.Type != new.Spec.Type || old.Spec.ExternalName != new.Spec.ExternalName || !reflect.DeepEqual(old.Spec.Ports, new.Spec.Ports) {
+		return true
+	}
+	return !reflect.DeepEqual(getSourceRanges(old), getSourceRanges(new))
+}
+
+// getAnnotations returns the microsegmentation annotations of a service
+func getAnnotations(service *corev1.Service) map[string]string {
+	annotations := map[string]string{}
+	for key, value := range service.GetAnnotations() {
+		if strings.HasPrefix(key, annotationBase+"/") && key != nextScheduleTransition {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// getSourceRanges returns the CIDRs allowed to connect to a LoadBalancer or NodePort service
+func getSourceRanges(service *corev1.Service) []string {
+	ranges := ""
+	switch service.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		if len(service.Spec.LoadBalancerSourceRanges) > 0 {
+			return service.Spec.LoadBalancerSourceRanges
+		}
+		ranges = service.Annotations[loadBalancerSourceRangesAnnotation]
+	case corev1.ServiceTypeNodePort:
+		ranges = service.Annotations[nodePortSourceRanges]
+	}
+	sourceRanges := []string{}
+	for _, sourceRange := range strings.Split(ranges, ",") {
+		if sourceRange = strings.TrimSpace(sourceRange); sourceRange != "" {
+			sourceRanges = append(sourceRanges, sourceRange)
+		}
+	}
+	return sourceRanges
+}
+
+// getIPBlockPeers returns a peer for each valid CIDR of cidrs
+func getIPBlockPeers(cidrs []string) []networking.NetworkPolicyPeer {
+	peers := []networking.NetworkPolicyPeer{}
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			log.Error(err, "unable to parse source range", "cidr", cidr)
+			continue
+		}
+		peers = append(peers, networking.NetworkPolicyPeer{
+			IPBlock: &networking.IPBlock{
+				CIDR: cidr,
+			},
+		})
+	}
+	return peers
+}
+
+// getInvalidCIDRs returns the CIDRs of cidrs that cannot be parsed
+func getInvalidCIDRs(cidrs []string) []string {
+	invalid := []string{}
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			invalid = append(invalid, cidr)
+		}
+	}
+	return invalid
+}
+
 func getPortsFromService(ports []corev1.ServicePort) []networking.NetworkPolicyPort {
 	networkPolicyPorts := []networking.NetworkPolicyPort{}
 	for _, port := range ports {
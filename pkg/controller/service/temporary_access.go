@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const temporaryAccessPodLabels = annotationBase + "/temporary-access-pod-labels"
+const temporaryAccessPorts = annotationBase + "/temporary-access-ports"
+const temporaryAccessExpiry = annotationBase + "/temporary-access-expiry"
+
+// reconcileTemporaryAccess grants the temporary-access pods inbound access to the pods selected by podSelector
+// until the expiry of the grant, then revokes it. It returns how long until the grant expires, zero when no
+// grant is active.
+func (r *ReconcileService) reconcileTemporaryAccess(service *corev1.Service, podSelector metav1.LabelSelector) (time.Duration, error) {
+	podLabels, ok := service.Annotations[temporaryAccessPodLabels]
+	if !ok || service.Annotations[microsgmentationAnnotation] != "true" {
+		return 0, r.revokeTemporaryAccess(service)
+	}
+	expiry, err := getTemporaryAccessExpiry(service)
+	if err != nil {
+		log.Error(err, "unable to parse temporary access expiry", "expiry", service.Annotations[temporaryAccessExpiry])
+		r.GetRecorder().Event(service, "Warning", "InvalidTemporaryAccessExpiry", fmt.Sprintf("%s must be an RFC3339 timestamp: %s", temporaryAccessExpiry, err.Error()))
+		return 0, r.revokeTemporaryAccess(service)
+	}
+	remaining := time.Until(expiry)
+	if remaining <= 0 {
+		return 0, r.revokeTemporaryAccess(service)
+	}
+
+	networkPolicy := getTemporaryAccessNetworkPolicy(service, podSelector, getLabelSelectorFromAnnotation(podLabels))
+	current, err := backend.Get(r.GetClient(), r.backend, networkPolicy.GetNamespace(), networkPolicy.GetName())
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, service, backend.ExcludeQuarantinedPods(networkPolicy))
+	if err != nil {
+		return 0, err
+	}
+	if current == nil || current.GetAnnotations()[temporaryAccessExpiry] != networkPolicy.GetAnnotations()[temporaryAccessExpiry] {
+		r.GetRecorder().Event(service, "Normal", "TemporaryAccessGranted", fmt.Sprintf("pods %s granted access until %s", podLabels, expiry.Format(time.RFC3339)))
+	}
+	return remaining, nil
+}
+
+// revokeTemporaryAccess deletes the temporary access policy of service, if any
+func (r *ReconcileService) revokeTemporaryAccess(service *corev1.Service) error {
+	networkPolicy := getTemporaryAccessNetworkPolicy(service, metav1.LabelSelector{}, &metav1.LabelSelector{})
+	err := backend.Delete(r.GetClient(), r.backend, networkPolicy)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	r.GetRecorder().Event(service, "Normal", "TemporaryAccessRevoked", fmt.Sprintf("temporary access policy %s deleted", networkPolicy.GetName()))
+	return nil
+}
+
+func getTemporaryAccessExpiry(service *corev1.Service) (time.Time, error) {
+	return time.Parse(time.RFC3339, service.Annotations[temporaryAccessExpiry])
+}
+
+func getTemporaryAccessNetworkPolicy(service *corev1.Service, podSelector metav1.LabelSelector, sourcePodSelector *metav1.LabelSelector) *networking.NetworkPolicy {
+	ports := getPortsFromAnnotation(service.Annotations[temporaryAccessPorts])
+	if len(ports) == 0 {
+		ports = getPortsFromService(service.Spec.Ports)
+	}
+	return &networking.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-" + service.GetName() + "-temporary-access",
+			Namespace: service.GetNamespace(),
+			Annotations: map[string]string{
+				temporaryAccessExpiry: service.Annotations[temporaryAccessExpiry],
+			},
+		},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: podSelector,
+			Ingress: []networking.NetworkPolicyIngressRule{{
+				From: []networking.NetworkPolicyPeer{{
+					PodSelector: sourcePodSelector,
+				}},
+				Ports: ports,
+			}},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress},
+		},
+	}
+}
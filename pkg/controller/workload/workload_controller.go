@@ -0,0 +1,385 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eformat/microsegmentation-operator/pkg/backend"
+	"github.com/eformat/microsegmentation-operator/pkg/quarantine"
+	"github.com/redhat-cop/operator-utils/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_workload")
+
+const annotationBase = "microsegmentation-operator.redhat-cop.io"
+const microsgmentationAnnotation = annotationBase + "/microsegmentation"
+const additionalInboundPortsAnnotation = annotationBase + "/additional-inbound-ports"
+const inboundPodLabels = annotationBase + "/inbound-pod-labels"
+const outboundPodLabels = annotationBase + "/outbound-pod-labels"
+const outboundPorts = annotationBase + "/outbound-ports"
+const controllerName = "workload-controller"
+
+// workload is a namespaced object owning a pod template
+type workload interface {
+	metav1.Object
+	runtime.Object
+}
+
+// workloadKind describes a kind of workload whose pod template drives a NetworkPolicy
+type workloadKind struct {
+	name        string
+	newObject   func() workload
+	podTemplate func(runtime.Object) *corev1.PodTemplateSpec
+	// gvk is set for kinds whose API may not be served, they are only watched when it is
+	gvk *schema.GroupVersionKind
+}
+
+var workloadKinds = []workloadKind{
+	{
+		name:      "deployment",
+		newObject: func() workload { return &appsv1.Deployment{} },
+		podTemplate: func(obj runtime.Object) *corev1.PodTemplateSpec {
+			return &obj.(*appsv1.Deployment).Spec.Template
+		},
+	},
+	{
+		name:      "statefulset",
+		newObject: func() workload { return &appsv1.StatefulSet{} },
+		podTemplate: func(obj runtime.Object) *corev1.PodTemplateSpec {
+			return &obj.(*appsv1.StatefulSet).Spec.Template
+		},
+	},
+	{
+		name:      "daemonset",
+		newObject: func() workload { return &appsv1.DaemonSet{} },
+		podTemplate: func(obj runtime.Object) *corev1.PodTemplateSpec {
+			return &obj.(*appsv1.DaemonSet).Spec.Template
+		},
+	},
+	{
+		name:      "cronjob",
+		newObject: func() workload { return &batchv1beta1.CronJob{} },
+		podTemplate: func(obj runtime.Object) *corev1.PodTemplateSpec {
+			return &obj.(*batchv1beta1.CronJob).Spec.JobTemplate.Spec.Template
+		},
+		gvk: &schema.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"},
+	},
+}
+
+// Add creates a new Workload Controller per workload kind and adds them to the Manager. The Manager will set fields on the Controllers
+// and Start them when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	policyBackend, err := backend.FromEnv()
+	if err != nil {
+		return err
+	}
+	for _, kind := range workloadKinds {
+		if kind.gvk != nil {
+			if _, err := mgr.GetRESTMapper().RESTMapping(kind.gvk.GroupKind(), kind.gvk.Version); err != nil {
+				log.Info(kind.gvk.GroupVersion().String()+" "+kind.gvk.Kind+" API not available, not watching", "Kind", kind.name)
+				continue
+			}
+		}
+		err := add(mgr, newReconciler(mgr, kind, policyBackend), kind, policyBackend)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager, kind workloadKind, policyBackend backend.Backend) reconcile.Reconciler {
+	return &ReconcileWorkload{
+		ReconcilerBase: util.NewReconcilerBase(mgr.GetClient(), mgr.GetScheme(), mgr.GetConfig(), mgr.GetRecorder(controllerName)),
+		kind:           kind,
+		backend:        policyBackend,
+	}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler, kind workloadKind, policyBackend backend.Backend) error {
+	// Create a new controller
+	c, err := controller.New(controllerName+"-"+kind.name, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	isAnnotatedWorkload := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldValue, _ := e.MetaOld.GetAnnotations()[microsgmentationAnnotation]
+			newValue, _ := e.MetaNew.GetAnnotations()[microsgmentationAnnotation]
+			old := oldValue == "true"
+			new := newValue == "true"
+			if old != new {
+				return true
+			}
+			if !new {
+				return false
+			}
+			if !reflect.DeepEqual(getAnnotations(e.MetaOld), getAnnotations(e.MetaNew)) {
+				return true
+			}
+			return !reflect.DeepEqual(kind.podTemplate(e.ObjectOld).Labels, kind.podTemplate(e.ObjectNew).Labels) ||
+				!reflect.DeepEqual(getPortsFromPodTemplate(kind.podTemplate(e.ObjectOld)), getPortsFromPodTemplate(kind.podTemplate(e.ObjectNew)))
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			value, _ := e.Meta.GetAnnotations()[microsgmentationAnnotation]
+			return value == "true"
+		},
+	}
+
+	// Watch for changes to primary resource
+	err = c.Watch(&source.Kind{Type: kind.newObject()}, &handler.EnqueueRequestForObject{}, isAnnotatedWorkload)
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource rendered policies and requeue the owner workload
+	err = c.Watch(&source.Kind{Type: policyBackend.ObjectType()}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    kind.newObject(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileWorkload{}
+
+// ReconcileWorkload reconciles a Deployment, StatefulSet, DaemonSet or CronJob object
+type ReconcileWorkload struct {
+	util.ReconcilerBase
+	kind    workloadKind
+	backend backend.Backend
+}
+
+// Reconcile reads that state of the cluster for a workload object and makes changes based on the state read
+// and what is in its pod template
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileWorkload) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Kind", r.kind.name, "Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling Workload")
+
+	// Fetch the workload instance
+	instance := r.kind.newObject()
+	err := r.GetClient().Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	// The object is being deleted
+	if !instance.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	// Quarantined namespaces only keep the quarantine policy, generated policies are restored when it is lifted
+	quarantined, err := quarantine.IsNamespaceQuarantined(r.GetClient(), instance.GetNamespace())
+	if err != nil {
+		log.Error(err, "unable to check namespace quarantine")
+		return r.manageError(err, instance)
+	}
+	if quarantined {
+		reqLogger.Info("namespace is quarantined, skipping")
+		return reconcile.Result{}, nil
+	}
+
+	networkPolicy := getNetworkPolicy(r.kind.name, instance, r.kind.podTemplate(instance))
+
+	if instance.GetAnnotations()[microsgmentationAnnotation] == "true" {
+		if len(networkPolicy.Spec.PodSelector.MatchLabels) == 0 {
+			// without template labels the policy would select every pod in the namespace
+			err = fmt.Errorf("pod template of %s %s has no labels", r.kind.name, instance.GetName())
+			r.GetRecorder().Event(instance, "Warning", "MissingPodLabels", err.Error())
+			return r.deleteNetworkPolicy(networkPolicy, instance)
+		}
+		err = backend.CreateOrUpdate(&r.ReconcilerBase, r.backend, instance, backend.ExcludeQuarantinedPods(networkPolicy))
+		if err != nil {
+			log.Error(err, "unable to create NetworkPolicy", "NetworkPolicy", networkPolicy)
+			return r.manageError(err, instance)
+		}
+	} else {
+		return r.deleteNetworkPolicy(networkPolicy, instance)
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func (r *ReconcileWorkload) deleteNetworkPolicy(networkPolicy *networking.NetworkPolicy, instance runtime.Object) (reconcile.Result, error) {
+	err := backend.Delete(r.GetClient(), r.backend, networkPolicy)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		log.Error(err, "unable to delete NetworkPolicy", "NetworkPolicy", networkPolicy)
+		return r.manageError(err, instance)
+	}
+	return reconcile.Result{}, nil
+}
+
+func getNetworkPolicy(kind string, instance metav1.Object, template *corev1.PodTemplateSpec) *networking.NetworkPolicy {
+	networkPolicy := &networking.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kind + "-" + instance.GetName(),
+			Namespace: instance.GetNamespace(),
+		},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: template.Labels,
+			},
+			Egress:  []networking.NetworkPolicyEgressRule{},
+			Ingress: []networking.NetworkPolicyIngressRule{},
+		},
+	}
+
+	annotations := instance.GetAnnotations()
+
+	// If we have inbound pod labels, also append container and annotation ports
+	if inboundPodLabels, ok := annotations[inboundPodLabels]; ok {
+		networkPolicyIngressRule := networking.NetworkPolicyIngressRule{
+			From: []networking.NetworkPolicyPeer{networking.NetworkPolicyPeer{
+				PodSelector: getLabelSelectorFromAnnotation(inboundPodLabels),
+			}},
+			Ports: append(getPortsFromPodTemplate(template), getPortsFromAnnotation(annotations[additionalInboundPortsAnnotation])...),
+		}
+		networkPolicy.Spec.Ingress = append(networkPolicy.Spec.Ingress, networkPolicyIngressRule)
+
+	} else { // just append annotation ports, no pod selector
+		networkPolicyIngressRule := networking.NetworkPolicyIngressRule{
+			Ports: append([]networking.NetworkPolicyPort{}, getPortsFromAnnotation(annotations[additionalInboundPortsAnnotation])...),
+		}
+		networkPolicy.Spec.Ingress = append(networkPolicy.Spec.Ingress, networkPolicyIngressRule)
+	}
+
+	if outboundPodLabels, ok := annotations[outboundPodLabels]; ok {
+		networkPolicyEgressRule := networking.NetworkPolicyEgressRule{
+			To: []networking.NetworkPolicyPeer{networking.NetworkPolicyPeer{
+				PodSelector: getLabelSelectorFromAnnotation(outboundPodLabels),
+			}},
+			Ports: getPortsFromAnnotation(annotations[outboundPorts]),
+		}
+		networkPolicy.Spec.Egress = append(networkPolicy.Spec.Egress, networkPolicyEgressRule)
+	}
+
+	return networkPolicy
+}
+
+// getAnnotations returns the microsegmentation annotations of a workload
+func getAnnotations(instance metav1.Object) map[string]string {
+	annotations := map[string]string{}
+	for key, value := range instance.GetAnnotations() {
+		if strings.HasPrefix(key, annotationBase+"/") {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// getPortsFromPodTemplate plays the role of the service ports for workloads not behind a Service
+func getPortsFromPodTemplate(template *corev1.PodTemplateSpec) []networking.NetworkPolicyPort {
+	networkPolicyPorts := []networking.NetworkPolicyPort{}
+	for _, container := range template.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			iport := intstr.FromInt(int(containerPort.ContainerPort))
+			iprotocol := containerPort.Protocol
+			if iprotocol == "" {
+				iprotocol = corev1.ProtocolTCP
+			}
+			networkPolicyPorts = append(networkPolicyPorts, networking.NetworkPolicyPort{
+				Port:     &iport,
+				Protocol: &iprotocol,
+			})
+		}
+	}
+	return networkPolicyPorts
+}
+
+func getPortsFromAnnotation(ports string) []networking.NetworkPolicyPort {
+	// this annotation looks like this: 9999/TCP,8888/UDP
+	networkPolicyPorts := []networking.NetworkPolicyPort{}
+	if ports == "" {
+		return networkPolicyPorts
+	}
+	portsStrings := strings.Split(ports, ",")
+	for _, portString := range portsStrings {
+		if strings.Index(portString, "/") < 1 {
+			log.Error(fmt.Errorf("Ports: %s ", ports), "check workload annotations - missing / sign ?", "port", portString)
+			continue
+		}
+		intport, err := strconv.Atoi(portString[:strings.Index(portString, "/")])
+		if err != nil {
+			log.Error(err, "unable to convert port to integer", "port", portString[:strings.Index(portString, "/")])
+			continue
+		}
+		port := intstr.FromInt(intport)
+		protocol := corev1.Protocol(strings.ToUpper(portString[strings.Index(portString, "/")+1:]))
+		networkPolicyPorts = append(networkPolicyPorts, networking.NetworkPolicyPort{
+			Port:     &port,
+			Protocol: &protocol,
+		})
+	}
+	return networkPolicyPorts
+}
+
+func getLabelSelectorFromAnnotation(labels string) *metav1.LabelSelector {
+	// this annotation looks like this: label1=value,label2=value2
+	labelMap := map[string]string{}
+	labelsStrings := strings.Split(labels, ",")
+	for _, labelString := range labelsStrings {
+		if strings.Index(labelString, "=") < 1 {
+			log.Error(fmt.Errorf("Labels: %s ", labels), "FATAL: check workload annotations - missing = sign ?", labels)
+			return &metav1.LabelSelector{
+				MatchLabels: labelMap,
+			}
+		}
+		label := labelString[:strings.Index(labelString, "=")]
+		value := labelString[strings.Index(labelString, "=")+1:]
+		labelMap[label] = value
+	}
+	return &metav1.LabelSelector{
+		MatchLabels: labelMap,
+	}
+}
+
+func (r *ReconcileWorkload) manageError(issue error, instance runtime.Object) (reconcile.Result, error) {
+	r.GetRecorder().Event(instance, "Warning", "ProcessingError", issue.Error())
+	return reconcile.Result{
+		RequeueAfter: time.Minute * 2,
+		Requeue:      true,
+	}, nil
+}
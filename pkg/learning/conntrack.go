@@ -0,0 +1,74 @@
+package learning
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var conntrackProtocols = map[string]corev1.Protocol{
+	"tcp":  corev1.ProtocolTCP,
+	"udp":  corev1.ProtocolUDP,
+	"sctp": corev1.ProtocolSCTP,
+}
+
+// conntrackSource reads the output of conntrack -L or conntrack -E, one connection per line:
+//
+//	tcp 6 431999 ESTABLISHED src=10.128.0.5 dst=172.30.12.7 sport=51234 dport=5432 src=10.129.0.7 dst=10.128.0.5 sport=5432 dport=51234 [ASSURED] mark=0 use=1
+//
+// The first tuple is the connection as opened, the second the reply. A service address translated to a pod is
+// only visible in the reply, so the destination is taken from the reply tuple when there is one.
+type conntrackSource struct {
+	reader io.Reader
+}
+
+func (s *conntrackSource) Flows() ([]Flow, error) {
+	flows := []Flow{}
+	scanner := bufio.NewScanner(s.reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		protocol := corev1.Protocol("")
+		tuples := []map[string]string{}
+		for _, field := range fields {
+			if p, ok := conntrackProtocols[field]; ok && protocol == "" {
+				protocol = p
+				continue
+			}
+			index := strings.Index(field, "=")
+			if protocol == "" || index < 1 {
+				continue
+			}
+			key, value := field[:index], field[index+1:]
+			// a new tuple starts at each src
+			if key == "src" {
+				tuples = append(tuples, map[string]string{})
+			}
+			if len(tuples) > 0 && len(tuples) <= 2 {
+				tuples[len(tuples)-1][key] = value
+			}
+		}
+		if protocol == "" || len(tuples) == 0 {
+			continue
+		}
+		flow := Flow{
+			Source:      Endpoint{IP: tuples[0]["src"]},
+			Destination: Endpoint{IP: tuples[0]["dst"]},
+			Protocol:    protocol,
+		}
+		port := tuples[0]["dport"]
+		if len(tuples) > 1 && tuples[1]["src"] != "" {
+			flow.Destination.IP = tuples[1]["src"]
+			port = tuples[1]["sport"]
+		}
+		number, err := strconv.ParseInt(port, 10, 32)
+		if err != nil || flow.Source.IP == "" || flow.Destination.IP == "" {
+			continue
+		}
+		flow.Port = int32(number)
+		flows = append(flows, flow)
+	}
+	return flows, scanner.Err()
+}
@@ -0,0 +1,65 @@
+package learning
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Endpoint is one end of an observed flow. Sources providing pod identities fill in the namespace and pod name,
+// the IP is resolved against the pods of the cluster otherwise.
+type Endpoint struct {
+	IP        string
+	Namespace string
+	Pod       string
+}
+
+// Flow is an observed connection, from the side that opened it
+type Flow struct {
+	Source      Endpoint
+	Destination Endpoint
+	Port        int32
+	Protocol    corev1.Protocol
+}
+
+// Source reads flows from the records of a flow exporter
+type Source interface {
+	Flows() ([]Flow, error)
+}
+
+// NewSourceFunc returns a Source reading records from r
+type NewSourceFunc func(r io.Reader) Source
+
+var sources = map[string]NewSourceFunc{}
+
+// Register makes a flow source available under name
+func Register(name string, newSource NewSourceFunc) {
+	sources[name] = newSource
+}
+
+// NewSource returns the source registered under name reading records from r
+func NewSource(name string, r io.Reader) (Source, error) {
+	newSource, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown flow format %s, expected one of %s", name, strings.Join(Formats(), ", "))
+	}
+	return newSource(r), nil
+}
+
+// Formats returns the names of the registered flow sources
+func Formats() []string {
+	names := []string{}
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register("conntrack", func(r io.Reader) Source { return &conntrackSource{reader: r} })
+	Register("hubble", func(r io.Reader) Source { return &hubbleSource{reader: r} })
+}
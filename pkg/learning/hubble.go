@@ -0,0 +1,81 @@
+package learning
+
+import (
+	"encoding/json"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// hubbleFlow holds the fields of a Hubble flow used to learn from, as printed by hubble observe -o json
+type hubbleFlow struct {
+	Verdict string `json:"verdict"`
+	IsReply *bool  `json:"is_reply"`
+	IP      struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+	} `json:"IP"`
+	L4 struct {
+		TCP  *hubblePorts `json:"TCP"`
+		UDP  *hubblePorts `json:"UDP"`
+		SCTP *hubblePorts `json:"SCTP"`
+	} `json:"l4"`
+	Source      hubbleEndpoint `json:"source"`
+	Destination hubbleEndpoint `json:"destination"`
+}
+
+type hubblePorts struct {
+	DestinationPort int32 `json:"destination_port"`
+}
+
+type hubbleEndpoint struct {
+	Namespace string `json:"namespace"`
+	PodName   string `json:"pod_name"`
+}
+
+// hubbleSource reads Hubble flows, one JSON object per line either bare or wrapped in a flow field. Replies and
+// flows that were not forwarded are skipped.
+type hubbleSource struct {
+	reader io.Reader
+}
+
+func (s *hubbleSource) Flows() ([]Flow, error) {
+	flows := []Flow{}
+	decoder := json.NewDecoder(s.reader)
+	for {
+		record := struct {
+			Flow *hubbleFlow `json:"flow"`
+			hubbleFlow
+		}{}
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hubble := &record.hubbleFlow
+		if record.Flow != nil {
+			hubble = record.Flow
+		}
+		if (hubble.Verdict != "" && hubble.Verdict != "FORWARDED") || (hubble.IsReply != nil && *hubble.IsReply) {
+			continue
+		}
+		flow := Flow{
+			Source:      Endpoint{IP: hubble.IP.Source, Namespace: hubble.Source.Namespace, Pod: hubble.Source.PodName},
+			Destination: Endpoint{IP: hubble.IP.Destination, Namespace: hubble.Destination.Namespace, Pod: hubble.Destination.PodName},
+		}
+		switch {
+		case hubble.L4.TCP != nil:
+			flow.Protocol, flow.Port = corev1.ProtocolTCP, hubble.L4.TCP.DestinationPort
+		case hubble.L4.UDP != nil:
+			flow.Protocol, flow.Port = corev1.ProtocolUDP, hubble.L4.UDP.DestinationPort
+		case hubble.L4.SCTP != nil:
+			flow.Protocol, flow.Port = corev1.ProtocolSCTP, hubble.L4.SCTP.DestinationPort
+		default:
+			continue
+		}
+		flows = append(flows, flow)
+	}
+	return flows, nil
+}
@@ -0,0 +1,392 @@
+package learning
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/eformat/microsegmentation-operator/pkg/reachability"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const annotationBase = "microsegmentation-operator.redhat-cop.io"
+const microsgmentationAnnotation = annotationBase + "/microsegmentation"
+const inboundNamespaceLabels = annotationBase + "/inbound-namespace-labels"
+const outboundNamespaceLabels = annotationBase + "/outbound-namespace-labels"
+const allowFromSelfLabel = annotationBase + "/allow-from-self"
+const inboundPodLabels = annotationBase + "/inbound-pod-labels"
+const additionalInboundPortsAnnotation = annotationBase + "/additional-inbound-ports"
+
+// DraftAnnotationBase prefixes the suggested annotations of drafts, which the operator does not act on
+const DraftAnnotationBase = "draft." + annotationBase
+
+// namespaceNameLabel is set by the API server on every namespace, namespaces are selected by it
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// labels set by workload controllers that change with every rollout
+var volatilePodLabels = []string{"pod-template-hash", "controller-revision-hash", "statefulset.kubernetes.io/pod-name", "pod-template-generation"}
+
+// Suggestion is the annotations proposed for a Namespace or Service, with the observations they come from
+type Suggestion struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	Annotations map[string]string
+	Evidence    []string
+}
+
+// Draft returns the annotations of s under DraftAnnotationBase, to record the suggestion on its object
+// without enforcing it
+func (s Suggestion) Draft() map[string]string {
+	draft := map[string]string{}
+	for key, value := range s.Annotations {
+		draft[strings.Replace(key, annotationBase+"/", DraftAnnotationBase+"/", 1)] = value
+	}
+	return draft
+}
+
+// Report is the outcome of learning from flows
+type Report struct {
+	Suggestions []Suggestion
+	// Notes lists the flows that could not be turned into annotations and what needs a review
+	Notes []string
+}
+
+// learner accumulates the flows observed per namespace and service
+type learner struct {
+	snapshot *reachability.Snapshot
+	// inbound and outbound namespaces, counted by namespace then peer namespace
+	inbound  map[string]map[string]int
+	outbound map[string]map[string]int
+	// namespaces with same-namespace flows no service annotation covers
+	self map[string]map[string]int
+	// source pods and additional ports, by service namespace/name
+	sources         map[string]map[string]*corev1.Pod
+	additionalPorts map[string]map[string]bool
+	services        map[string]*corev1.Service
+	notes           map[string]int
+}
+
+// Learn proposes the annotations allowing the flows observed between the pods of snapshot. Ingress is learnt
+// from every flow, egress only when egress is set as restricting egress blocks any destination not observed.
+func Learn(snapshot *reachability.Snapshot, flows []Flow, egress bool) *Report {
+	l := &learner{
+		snapshot:        snapshot,
+		inbound:         map[string]map[string]int{},
+		outbound:        map[string]map[string]int{},
+		self:            map[string]map[string]int{},
+		sources:         map[string]map[string]*corev1.Pod{},
+		additionalPorts: map[string]map[string]bool{},
+		services:        map[string]*corev1.Service{},
+		notes:           map[string]int{},
+	}
+	for _, flow := range flows {
+		l.add(flow, egress)
+	}
+	return l.report(egress)
+}
+
+func (l *learner) add(flow Flow, egress bool) {
+	source := l.findPod(flow.Source)
+	destination := l.findPod(flow.Destination)
+	service := l.findServiceByClusterIP(flow.Destination.IP, flow.Port, flow.Protocol)
+	port := fmt.Sprintf("%d/%s", flow.Port, flow.Protocol)
+
+	if destination == nil && service == nil {
+		if egress && source != nil {
+			l.notes[fmt.Sprintf("%s/%s connects to %s %s outside the cluster, allow it with outbound-external-cidrs", source.GetNamespace(), source.GetName(), flow.Destination.IP, port)]++
+		}
+		return
+	}
+	destinationNamespace := ""
+	if destination != nil {
+		destinationNamespace = destination.GetNamespace()
+	} else {
+		destinationNamespace = service.GetNamespace()
+	}
+	if source == nil {
+		l.notes[fmt.Sprintf("%s connects to %s %s from outside the cluster, review the source ranges of the service", flow.Source.IP, l.describe(destination, service), port)]++
+		return
+	}
+
+	sourceNamespace := source.GetNamespace()
+	if egress {
+		count(l.outbound, sourceNamespace, destinationNamespace)
+	}
+	if sourceNamespace != destinationNamespace {
+		count(l.inbound, destinationNamespace, sourceNamespace)
+		return
+	}
+
+	// same-namespace flows are allowed by the inbound pod labels of the service they reach
+	if service != nil {
+		l.addSource(service, source)
+		return
+	}
+	services := l.findServicesSelecting(destination)
+	for _, candidate := range services {
+		for _, candidatePort := range candidate.Spec.Ports {
+			if getProtocol(candidatePort.Protocol) == flow.Protocol && resolveTargetPort(candidatePort, destination) == flow.Port {
+				l.addSource(candidate, source)
+				return
+			}
+		}
+	}
+	if len(services) > 0 {
+		l.addSource(services[0], source)
+		l.addAdditionalPort(services[0], port)
+		return
+	}
+	count(l.self, sourceNamespace, fmt.Sprintf("%s -> %s %s", source.GetName(), destination.GetName(), port))
+}
+
+func (l *learner) addSource(service *corev1.Service, source *corev1.Pod) {
+	key := service.GetNamespace() + "/" + service.GetName()
+	l.services[key] = service
+	if l.sources[key] == nil {
+		l.sources[key] = map[string]*corev1.Pod{}
+	}
+	l.sources[key][source.GetName()] = source
+}
+
+func (l *learner) addAdditionalPort(service *corev1.Service, port string) {
+	key := service.GetNamespace() + "/" + service.GetName()
+	if l.additionalPorts[key] == nil {
+		l.additionalPorts[key] = map[string]bool{}
+	}
+	l.additionalPorts[key][port] = true
+}
+
+func (l *learner) report(egress bool) *Report {
+	report := &Report{Suggestions: []Suggestion{}, Notes: []string{}}
+	namespaces := map[string]*Suggestion{}
+	getNamespace := func(name string) *Suggestion {
+		if namespaces[name] == nil {
+			namespaces[name] = &Suggestion{Kind: "Namespace", Name: name, Annotations: map[string]string{microsgmentationAnnotation: "true"}, Evidence: []string{}}
+		}
+		return namespaces[name]
+	}
+
+	for namespace, peers := range l.inbound {
+		suggestion := getNamespace(namespace)
+		suggestion.Annotations[inboundNamespaceLabels] = getNamespaceLabels(peers)
+		for _, peer := range sortedKeys(peers) {
+			suggestion.Evidence = append(suggestion.Evidence, fmt.Sprintf("%d flows from namespace %s", peers[peer], peer))
+		}
+	}
+	if egress {
+		for namespace, peers := range l.outbound {
+			suggestion := getNamespace(namespace)
+			suggestion.Annotations[outboundNamespaceLabels] = getNamespaceLabels(peers)
+			for _, peer := range sortedKeys(peers) {
+				suggestion.Evidence = append(suggestion.Evidence, fmt.Sprintf("%d flows to namespace %s", peers[peer], peer))
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range l.sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	services := []Suggestion{}
+	for _, key := range keys {
+		service := l.services[key]
+		podLabels := getCommonLabels(l.sources[key])
+		names := []string{}
+		for name := range l.sources[key] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(podLabels) == 0 {
+			// sources sharing no label can only be allowed for the whole namespace
+			suggestion := getNamespace(service.GetNamespace())
+			suggestion.Annotations[allowFromSelfLabel] = "true"
+			suggestion.Evidence = append(suggestion.Evidence, fmt.Sprintf("service %s reached by pods sharing no label: %s", service.GetName(), strings.Join(names, ", ")))
+			continue
+		}
+		getNamespace(service.GetNamespace())
+		suggestion := Suggestion{
+			Kind:      "Service",
+			Namespace: service.GetNamespace(),
+			Name:      service.GetName(),
+			Annotations: map[string]string{
+				microsgmentationAnnotation: "true",
+				inboundPodLabels:           labels.Set(podLabels).String(),
+			},
+			Evidence: []string{fmt.Sprintf("reached from pods %s", strings.Join(names, ", "))},
+		}
+		ports := []string{}
+		for port := range l.additionalPorts[key] {
+			ports = append(ports, port)
+		}
+		sort.Strings(ports)
+		if len(ports) > 0 {
+			suggestion.Annotations[additionalInboundPortsAnnotation] = strings.Join(ports, ",")
+			suggestion.Evidence = append(suggestion.Evidence, fmt.Sprintf("reached on ports %s outside the service ports", strings.Join(ports, ", ")))
+		}
+		services = append(services, suggestion)
+	}
+	for namespace, observed := range l.self {
+		suggestion := getNamespace(namespace)
+		suggestion.Annotations[allowFromSelfLabel] = "true"
+		for _, flow := range sortedKeys(observed) {
+			suggestion.Evidence = append(suggestion.Evidence, fmt.Sprintf("%d flows %s to pods selected by no service", observed[flow], flow))
+		}
+	}
+
+	names := []string{}
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.Suggestions = append(report.Suggestions, *namespaces[name])
+	}
+	report.Suggestions = append(report.Suggestions, services...)
+	for _, note := range sortedKeys(l.notes) {
+		report.Notes = append(report.Notes, fmt.Sprintf("%s (%d flows)", note, l.notes[note]))
+	}
+	for i := range l.snapshot.Services {
+		service := &l.snapshot.Services[i]
+		key := service.GetNamespace() + "/" + service.GetName()
+		if namespaces[service.GetNamespace()] != nil && l.sources[key] == nil && len(service.Spec.Selector) > 0 {
+			report.Notes = append(report.Notes, fmt.Sprintf("no flow observed to service %s from its namespace, only namespace rules will allow traffic to it", key))
+		}
+	}
+	return report
+}
+
+// findPod returns the pod of endpoint, by name when the source identified it or by IP
+func (l *learner) findPod(endpoint Endpoint) *corev1.Pod {
+	if endpoint.Namespace != "" && endpoint.Pod != "" {
+		if pod := l.snapshot.FindPod(endpoint.Namespace, endpoint.Pod); pod != nil {
+			return pod
+		}
+	}
+	if endpoint.IP == "" {
+		return nil
+	}
+	for i := range l.snapshot.Pods {
+		pod := &l.snapshot.Pods[i]
+		// host network pods share the address of their node
+		if pod.Status.PodIP == endpoint.IP && !pod.Spec.HostNetwork && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			return pod
+		}
+	}
+	return nil
+}
+
+// findServiceByClusterIP returns the service with address ip and a port matching port and protocol
+func (l *learner) findServiceByClusterIP(ip string, port int32, protocol corev1.Protocol) *corev1.Service {
+	for i := range l.snapshot.Services {
+		service := &l.snapshot.Services[i]
+		if ip == "" || service.Spec.ClusterIP != ip {
+			continue
+		}
+		for _, servicePort := range service.Spec.Ports {
+			if servicePort.Port == port && getProtocol(servicePort.Protocol) == protocol {
+				return service
+			}
+		}
+	}
+	return nil
+}
+
+// findServicesSelecting returns the services selecting pod, sorted by name
+func (l *learner) findServicesSelecting(pod *corev1.Pod) []*corev1.Service {
+	services := []*corev1.Service{}
+	for i := range l.snapshot.Services {
+		service := &l.snapshot.Services[i]
+		if service.GetNamespace() != pod.GetNamespace() || len(service.Spec.Selector) == 0 {
+			continue
+		}
+		if labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(pod.GetLabels())) {
+			services = append(services, service)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].GetName() < services[j].GetName() })
+	return services
+}
+
+func (l *learner) describe(pod *corev1.Pod, service *corev1.Service) string {
+	if service != nil {
+		return "service " + service.GetNamespace() + "/" + service.GetName()
+	}
+	return "pod " + pod.GetNamespace() + "/" + pod.GetName()
+}
+
+// resolveTargetPort returns the number of the target port of servicePort on pod, 0 if not found
+func resolveTargetPort(servicePort corev1.ServicePort, pod *corev1.Pod) int32 {
+	if servicePort.TargetPort.StrVal == "" {
+		if servicePort.TargetPort.IntVal == 0 {
+			return servicePort.Port
+		}
+		return servicePort.TargetPort.IntVal
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == servicePort.TargetPort.StrVal {
+				return containerPort.ContainerPort
+			}
+		}
+	}
+	return 0
+}
+
+// getCommonLabels returns the labels all pods share, rollout specific labels excluded
+func getCommonLabels(pods map[string]*corev1.Pod) map[string]string {
+	common := map[string]string{}
+	first := true
+	for _, pod := range pods {
+		if first {
+			for key, value := range pod.GetLabels() {
+				common[key] = value
+			}
+			first = false
+			continue
+		}
+		for key, value := range common {
+			if pod.GetLabels()[key] != value {
+				delete(common, key)
+			}
+		}
+	}
+	for _, label := range volatilePodLabels {
+		delete(common, label)
+	}
+	return common
+}
+
+// getNamespaceLabels returns the namespace label list selecting peers
+func getNamespaceLabels(peers map[string]int) string {
+	selectors := []string{}
+	for _, peer := range sortedKeys(peers) {
+		selectors = append(selectors, namespaceNameLabel+"="+peer)
+	}
+	return strings.Join(selectors, ",")
+}
+
+func getProtocol(protocol corev1.Protocol) corev1.Protocol {
+	if protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return protocol
+}
+
+func count(counts map[string]map[string]int, key string, value string) {
+	if counts[key] == nil {
+		counts[key] = map[string]int{}
+	}
+	counts[key][value]++
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+package quarantine
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotation quarantines the namespace it is set on
+const Annotation = "microsegmentation-operator.redhat-cop.io/quarantine"
+
+// PodLabel quarantines the pod it is set on, generated policies never select pods carrying it
+const PodLabel = Annotation
+
+// ConfigMapName is the cluster level quarantine trigger, in the operator namespace. Its namespaces key lists the
+// quarantined namespaces, * quarantines every namespace enrolled in microsegmentation.
+const ConfigMapName = "microsegmentation-quarantine"
+const configMapNamespacesKey = "namespaces"
+
+// OperatorNamespaceEnv is the environment variable holding the namespace the operator runs in
+const OperatorNamespaceEnv = "OPERATOR_NAMESPACE"
+
+const microsegmentationAnnotation = "microsegmentation-operator.redhat-cop.io/microsegmentation"
+
+// IsQuarantined reports whether namespace is quarantined by its annotation or by the cluster trigger
+func IsQuarantined(c client.Client, namespace *corev1.Namespace) (bool, error) {
+	if namespace.Annotations[Annotation] == "true" {
+		return true, nil
+	}
+	operatorNamespace := os.Getenv(OperatorNamespaceEnv)
+	if operatorNamespace == "" {
+		return false, nil
+	}
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(context.TODO(), types.NamespacedName{Namespace: operatorNamespace, Name: ConfigMapName}, configMap)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, name := range strings.Split(configMap.Data[configMapNamespacesKey], ",") {
+		name = strings.TrimSpace(name)
+		if name == namespace.GetName() || (name == "*" && namespace.Annotations[microsegmentationAnnotation] == "true") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IsNamespaceQuarantined reports whether the namespace called name is quarantined
+func IsNamespaceQuarantined(c client.Client, name string) (bool, error) {
+	namespace := &corev1.Namespace{}
+	err := c.Get(context.TODO(), types.NamespacedName{Name: name}, namespace)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return IsQuarantined(c, namespace)
+}
+
+// IsTrigger reports whether the object called name in namespace is the cluster level quarantine trigger
+func IsTrigger(namespace string, name string) bool {
+	return name == ConfigMapName && namespace != "" && namespace == os.Getenv(OperatorNamespaceEnv)
+}
@@ -0,0 +1,297 @@
+package reachability
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FindingType classifies the rules reported by Analyze
+type FindingType string
+
+const (
+	// OverlyBroad rules allow every port from or to every peer
+	OverlyBroad FindingType = "OverlyBroad"
+	// Duplicate rules allow exactly what an earlier rule allows
+	Duplicate FindingType = "Duplicate"
+	// Shadowed rules allow nothing another rule does not already allow
+	Shadowed FindingType = "Shadowed"
+)
+
+// RuleRef identifies a rule of a NetworkPolicy
+type RuleRef struct {
+	Policy PolicyRef
+	// Direction is ingress or egress
+	Direction string
+	// Index is the position of the rule in the ingress or egress rules of the policy, from 0
+	Index int
+}
+
+func (r RuleRef) String() string {
+	return fmt.Sprintf("%s rule %d of %s", r.Direction, r.Index+1, r.Policy)
+}
+
+// Finding is a rule that is overly broad, or made moot by another rule
+type Finding struct {
+	Type FindingType
+	Rule RuleRef
+	// By is the rule duplicating or shadowing Rule, nil for overly broad rules
+	By *RuleRef
+	// Peers describes what an overly broad rule allows
+	Peers string
+}
+
+func (f Finding) String() string {
+	switch f.Type {
+	case Duplicate:
+		return fmt.Sprintf("%s duplicates %s", f.Rule, f.By)
+	case Shadowed:
+		return fmt.Sprintf("%s is shadowed by %s", f.Rule, f.By)
+	}
+	return fmt.Sprintf("%s allows every port %s %s", f.Rule, map[string]string{"ingress": "from", "egress": "to"}[f.Rule.Direction], f.Peers)
+}
+
+// rule is an ingress or egress rule with the pods its policy selects
+type rule struct {
+	ref         RuleRef
+	podSelector *metav1.LabelSelector
+	peers       []networking.NetworkPolicyPeer
+	ports       []networking.NetworkPolicyPort
+}
+
+// Analyze finds the overly broad, duplicate and shadowed rules among the NetworkPolicies of namespace. The
+// comparison is made on the selectors, a rule is only reported shadowed when another rule allows at least the same
+// peers and ports to at least the same pods whatever the pods of the cluster.
+func Analyze(namespace *corev1.Namespace, networkPolicies []networking.NetworkPolicy) []Finding {
+	sorted := make([]*networking.NetworkPolicy, 0, len(networkPolicies))
+	for i := range networkPolicies {
+		if networkPolicies[i].GetNamespace() == namespace.GetName() {
+			sorted = append(sorted, &networkPolicies[i])
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+
+	rules := []rule{}
+	for _, networkPolicy := range sorted {
+		ref := getPolicyRef(networkPolicy)
+		ingress, egress := policyTypes(networkPolicy)
+		if ingress {
+			for i, ingressRule := range networkPolicy.Spec.Ingress {
+				rules = append(rules, rule{
+					ref:         RuleRef{Policy: ref, Direction: "ingress", Index: i},
+					podSelector: &networkPolicy.Spec.PodSelector,
+					peers:       ingressRule.From,
+					ports:       ingressRule.Ports,
+				})
+			}
+		}
+		if egress {
+			for i, egressRule := range networkPolicy.Spec.Egress {
+				rules = append(rules, rule{
+					ref:         RuleRef{Policy: ref, Direction: "egress", Index: i},
+					podSelector: &networkPolicy.Spec.PodSelector,
+					peers:       egressRule.To,
+					ports:       egressRule.Ports,
+				})
+			}
+		}
+	}
+
+	findings := []Finding{}
+	for i := range rules {
+		if peers := getBroadPeers(&rules[i]); peers != "" {
+			findings = append(findings, Finding{Type: OverlyBroad, Rule: rules[i].ref, Peers: peers})
+		}
+		// a duplicate is reported rather than the rules shadowing both copies
+		var finding *Finding
+		for j := range rules {
+			if i == j || !ruleCovers(namespace, &rules[j], &rules[i]) {
+				continue
+			}
+			by := rules[j].ref
+			if !ruleCovers(namespace, &rules[i], &rules[j]) {
+				if finding == nil {
+					finding = &Finding{Type: Shadowed, Rule: rules[i].ref, By: &by}
+				}
+				continue
+			}
+			// the earlier of two equivalent rules is the one kept
+			if j < i {
+				finding = &Finding{Type: Duplicate, Rule: rules[i].ref, By: &by}
+				break
+			}
+		}
+		if finding != nil {
+			findings = append(findings, *finding)
+		}
+	}
+	return findings
+}
+
+// getBroadPeers describes the peers of a rule allowing every port from or to everything, empty otherwise
+func getBroadPeers(r *rule) string {
+	if len(r.ports) > 0 {
+		return ""
+	}
+	if len(r.peers) == 0 {
+		return "everywhere"
+	}
+	for _, peer := range r.peers {
+		if peer.IPBlock != nil {
+			_, cidr, err := net.ParseCIDR(peer.IPBlock.CIDR)
+			if err != nil || len(peer.IPBlock.Except) > 0 {
+				continue
+			}
+			if ones, _ := cidr.Mask.Size(); ones == 0 {
+				return peer.IPBlock.CIDR
+			}
+			continue
+		}
+		if peer.NamespaceSelector != nil && isEmptySelector(peer.NamespaceSelector) && (peer.PodSelector == nil || isEmptySelector(peer.PodSelector)) {
+			return "every pod of every namespace"
+		}
+	}
+	return ""
+}
+
+// ruleCovers reports whether s allows at least what r allows
+func ruleCovers(namespace *corev1.Namespace, s *rule, r *rule) bool {
+	if s.ref.Direction != r.ref.Direction || !selectorCovers(s.podSelector, r.podSelector) {
+		return false
+	}
+	return peersCover(namespace, s.peers, r.peers) && portsCover(s.ports, r.ports)
+}
+
+// peersCover reports whether the peers s match at least the peers r, no peers matching everything
+func peersCover(namespace *corev1.Namespace, s []networking.NetworkPolicyPeer, r []networking.NetworkPolicyPeer) bool {
+	if len(s) == 0 {
+		return true
+	}
+	if len(r) == 0 {
+		return false
+	}
+	for i := range r {
+		covered := false
+		for j := range s {
+			if peerCovers(namespace, &s[j], &r[i]) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// peerCovers reports whether the peer s matches at least the peer r
+func peerCovers(namespace *corev1.Namespace, s *networking.NetworkPolicyPeer, r *networking.NetworkPolicyPeer) bool {
+	if s.IPBlock != nil || r.IPBlock != nil {
+		if s.IPBlock == nil || r.IPBlock == nil || len(s.IPBlock.Except) > 0 {
+			return false
+		}
+		return cidrCovers(s.IPBlock.CIDR, r.IPBlock.CIDR)
+	}
+	switch {
+	case s.NamespaceSelector == nil && r.NamespaceSelector != nil:
+		return false
+	case s.NamespaceSelector != nil && r.NamespaceSelector == nil:
+		// a namespace selector matching the namespace covers the pods of the namespace
+		if !selectorMatches(s.NamespaceSelector, namespace.GetLabels()) {
+			return false
+		}
+	case s.NamespaceSelector != nil && r.NamespaceSelector != nil:
+		if !selectorCovers(s.NamespaceSelector, r.NamespaceSelector) {
+			return false
+		}
+	}
+	return selectorCovers(orEmpty(s.PodSelector), orEmpty(r.PodSelector))
+}
+
+// portsCover reports whether the ports s include at least the ports r, no ports matching every port
+func portsCover(s []networking.NetworkPolicyPort, r []networking.NetworkPolicyPort) bool {
+	if len(s) == 0 {
+		return true
+	}
+	if len(r) == 0 {
+		return false
+	}
+	for _, rPort := range r {
+		covered := false
+		for _, sPort := range s {
+			if getPortProtocol(sPort) != getPortProtocol(rPort) {
+				continue
+			}
+			if sPort.Port == nil || (rPort.Port != nil && *sPort.Port == *rPort.Port) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// selectorCovers reports whether s selects at least the objects r selects, every requirement of s being one of r.
+// Expressions are compared as written, not by what they select.
+func selectorCovers(s *metav1.LabelSelector, r *metav1.LabelSelector) bool {
+	for key, value := range s.MatchLabels {
+		if rValue, ok := r.MatchLabels[key]; !ok || rValue != value {
+			return false
+		}
+	}
+	for _, expression := range s.MatchExpressions {
+		found := false
+		for _, rExpression := range r.MatchExpressions {
+			if reflect.DeepEqual(expression, rExpression) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// cidrCovers reports whether the range s contains the range r
+func cidrCovers(s string, r string) bool {
+	_, sCIDR, err := net.ParseCIDR(s)
+	if err != nil {
+		return false
+	}
+	_, rCIDR, err := net.ParseCIDR(r)
+	if err != nil {
+		return false
+	}
+	sOnes, sBits := sCIDR.Mask.Size()
+	rOnes, rBits := rCIDR.Mask.Size()
+	return sBits == rBits && sOnes <= rOnes && sCIDR.Contains(rCIDR.IP)
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+func orEmpty(selector *metav1.LabelSelector) *metav1.LabelSelector {
+	if selector == nil {
+		return &metav1.LabelSelector{}
+	}
+	return selector
+}
+
+func getPortProtocol(port networking.NetworkPolicyPort) corev1.Protocol {
+	if port.Protocol == nil || *port.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return *port.Protocol
+}
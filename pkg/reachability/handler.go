@@ -0,0 +1,88 @@
+package reachability
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MatrixPath is the path the connectivity matrix is served on
+const MatrixPath = "/connectivity"
+
+var contentTypes = map[string]string{
+	"dot":     "text/vnd.graphviz; charset=utf-8",
+	"mermaid": "text/plain; charset=utf-8",
+	"json":    "application/json",
+}
+
+// MatrixHandler serves the connectivity matrix of the cluster read with c. The format query parameter selects
+// dot, mermaid or json, json by default, and the namespaces parameter restricts the matrix to a comma separated
+// list of namespaces. Matrices are computed at most once every ttl for the same parameters.
+func MatrixHandler(c client.Reader, ttl time.Duration) http.Handler {
+	cache := &matrixCache{ttl: ttl}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		contentType, ok := contentTypes[format]
+		if !ok {
+			http.Error(w, "format must be one of "+strings.Join(Formats, ", "), http.StatusBadRequest)
+			return
+		}
+		namespaces := []string{}
+		for _, namespace := range strings.Split(r.URL.Query().Get("namespaces"), ",") {
+			if namespace = strings.TrimSpace(namespace); namespace != "" {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+
+		body, err := cache.get(c, format, namespaces)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	})
+}
+
+// matrixCache holds the matrices written since the cluster was last read, by format and namespaces
+type matrixCache struct {
+	ttl      time.Duration
+	lock     sync.Mutex
+	read     time.Time
+	snapshot *Snapshot
+	matrices map[string][]byte
+}
+
+func (m *matrixCache) get(c client.Reader, format string, namespaces []string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.snapshot == nil || time.Since(m.read) >= m.ttl {
+		snapshot, err := FromCluster(c)
+		if err != nil {
+			return nil, err
+		}
+		m.snapshot, m.read, m.matrices = snapshot, time.Now(), map[string][]byte{}
+	}
+	key := format + "/" + strings.Join(namespaces, ",")
+	if body, ok := m.matrices[key]; ok {
+		return body, nil
+	}
+	var body bytes.Buffer
+	err := m.snapshot.Matrix(namespaces).Write(&body, format)
+	if err != nil {
+		return nil, err
+	}
+	m.matrices[key] = body.Bytes()
+	return m.matrices[key], nil
+}
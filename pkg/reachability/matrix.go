@@ -0,0 +1,237 @@
+package reachability
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Connection is the reachability of a service port from the pods of a namespace
+type Connection struct {
+	// From is the source namespace
+	From string `json:"from"`
+	// To is the destination service, namespace/name
+	To       string          `json:"to"`
+	Port     int32           `json:"port"`
+	Protocol corev1.Protocol `json:"protocol"`
+	// Sources is the number of pods of the source namespace, Allowed the number of them reaching at least one pod
+	// backing the service port
+	Sources int `json:"sources"`
+	Allowed int `json:"allowed"`
+}
+
+// Partial reports whether only some of the source pods reach the service port
+func (c Connection) Partial() bool {
+	return c.Allowed > 0 && c.Allowed < c.Sources
+}
+
+// Matrix is the reachability of every service port from every namespace of a snapshot
+type Matrix struct {
+	Namespaces  []string     `json:"namespaces"`
+	Services    []string     `json:"services"`
+	Connections []Connection `json:"connections"`
+}
+
+// Matrix computes the connectivity matrix between the namespaces and the services of the snapshot, restricted to
+// namespaces when not empty. Namespaces without pods are represented by an unlabelled pod, services without a
+// selector or without pods are left out.
+func (s *Snapshot) Matrix(namespaces []string) *Matrix {
+	included := func(name string) bool {
+		if len(namespaces) == 0 {
+			return true
+		}
+		for _, namespace := range namespaces {
+			if namespace == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	matrix := &Matrix{Namespaces: []string{}, Services: []string{}, Connections: []Connection{}}
+	sources := map[string][]*corev1.Pod{}
+	for _, namespace := range s.Namespaces {
+		if included(namespace.GetName()) {
+			sources[namespace.GetName()] = []*corev1.Pod{}
+		}
+	}
+	for i := range s.Pods {
+		pod := &s.Pods[i]
+		if !included(pod.GetNamespace()) || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		sources[pod.GetNamespace()] = append(sources[pod.GetNamespace()], pod)
+	}
+	for namespace, pods := range sources {
+		if len(pods) == 0 {
+			sources[namespace] = []*corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "*", Namespace: namespace}}}
+		}
+		matrix.Namespaces = append(matrix.Namespaces, namespace)
+	}
+	sort.Strings(matrix.Namespaces)
+
+	for i := range s.Services {
+		service := &s.Services[i]
+		if !included(service.GetNamespace()) {
+			continue
+		}
+		name := service.GetNamespace() + "/" + service.GetName()
+		serviceIncluded := false
+		for _, servicePort := range service.Spec.Ports {
+			targets := s.ServiceTargets(service, servicePort.Port)
+			if len(targets) == 0 {
+				continue
+			}
+			serviceIncluded = true
+			for _, namespace := range matrix.Namespaces {
+				connection := Connection{
+					From:     namespace,
+					To:       name,
+					Port:     servicePort.Port,
+					Protocol: targets[0].Protocol,
+					Sources:  len(sources[namespace]),
+				}
+				for _, source := range sources[namespace] {
+					for _, target := range targets {
+						if s.Check(source, target.Pod, target.Port, target.Protocol).Allowed {
+							connection.Allowed++
+							break
+						}
+					}
+				}
+				matrix.Connections = append(matrix.Connections, connection)
+			}
+		}
+		if serviceIncluded {
+			matrix.Services = append(matrix.Services, name)
+		}
+	}
+	sort.Strings(matrix.Services)
+	sort.SliceStable(matrix.Connections, func(i, j int) bool {
+		a, b := matrix.Connections[i], matrix.Connections[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Port < b.Port
+	})
+	return matrix
+}
+
+// Formats lists the formats a matrix can be written in
+var Formats = []string{"dot", "mermaid", "json"}
+
+// Write writes the matrix in format, one of Formats
+func (m *Matrix) Write(w io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return m.WriteDOT(w)
+	case "mermaid":
+		return m.WriteMermaid(w)
+	case "json":
+		return m.WriteJSON(w)
+	}
+	return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// WriteJSON writes every connection of the matrix, allowed or not, as JSON
+func (m *Matrix) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// WriteDOT writes the allowed connections as a Graphviz graph, services clustered by namespace. Connections
+// allowed for some of the source pods only are dashed.
+func (m *Matrix) WriteDOT(w io.Writer) error {
+	lines := []string{"digraph connectivity {", "  rankdir=LR;", "  node [fontname=\"sans-serif\"];"}
+	for _, namespace := range m.Namespaces {
+		lines = append(lines, fmt.Sprintf("  subgraph %q {", "cluster_"+namespace))
+		lines = append(lines, fmt.Sprintf("    label=%q;", namespace))
+		lines = append(lines, fmt.Sprintf("    %q [label=%q, shape=box, style=rounded];", "ns/"+namespace, namespace+" pods"))
+		for _, service := range m.Services {
+			if strings.HasPrefix(service, namespace+"/") {
+				lines = append(lines, fmt.Sprintf("    %q [label=%q, shape=ellipse];", "svc/"+service, strings.TrimPrefix(service, namespace+"/")))
+			}
+		}
+		lines = append(lines, "  }")
+	}
+	for _, edge := range m.edges() {
+		attributes := fmt.Sprintf("label=%q", edge.label)
+		if edge.partial {
+			attributes += ", style=dashed"
+		}
+		lines = append(lines, fmt.Sprintf("  %q -> %q [%s];", "ns/"+edge.from, "svc/"+edge.to, attributes))
+	}
+	lines = append(lines, "}")
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// WriteMermaid writes the allowed connections as a Mermaid flowchart, services grouped by namespace. Connections
+// allowed for some of the source pods only are dotted.
+func (m *Matrix) WriteMermaid(w io.Writer) error {
+	ids := map[string]string{}
+	id := func(name string) string {
+		if _, ok := ids[name]; !ok {
+			ids[name] = fmt.Sprintf("n%d", len(ids))
+		}
+		return ids[name]
+	}
+	lines := []string{"flowchart LR"}
+	for _, namespace := range m.Namespaces {
+		lines = append(lines, fmt.Sprintf("  subgraph %s [\"%s\"]", id("ns-group/"+namespace), namespace))
+		lines = append(lines, fmt.Sprintf("    %s([\"%s pods\"])", id("ns/"+namespace), namespace))
+		for _, service := range m.Services {
+			if strings.HasPrefix(service, namespace+"/") {
+				lines = append(lines, fmt.Sprintf("    %s[\"%s\"]", id("svc/"+service), strings.TrimPrefix(service, namespace+"/")))
+			}
+		}
+		lines = append(lines, "  end")
+	}
+	for _, edge := range m.edges() {
+		arrow := "-->"
+		if edge.partial {
+			arrow = "-.->"
+		}
+		lines = append(lines, fmt.Sprintf("  %s %s|\"%s\"| %s", id("ns/"+edge.from), arrow, edge.label, id("svc/"+edge.to)))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// edge is an allowed connection of a graph, the allowed ports of a service grouped in its label
+type edge struct {
+	from    string
+	to      string
+	label   string
+	partial bool
+}
+
+func (m *Matrix) edges() []edge {
+	edges := []edge{}
+	for _, connection := range m.Connections {
+		if connection.Allowed == 0 {
+			continue
+		}
+		port := fmt.Sprintf("%d/%s", connection.Port, connection.Protocol)
+		if connection.Partial() {
+			port += fmt.Sprintf(" (%d/%d pods)", connection.Allowed, connection.Sources)
+		}
+		last := len(edges) - 1
+		if last >= 0 && edges[last].from == connection.From && edges[last].to == connection.To {
+			edges[last].label += ", " + port
+			edges[last].partial = edges[last].partial && connection.Partial()
+			continue
+		}
+		edges = append(edges, edge{from: connection.From, to: connection.To, label: port, partial: connection.Partial()})
+	}
+	return edges
+}
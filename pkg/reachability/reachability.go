@@ -0,0 +1,307 @@
+package reachability
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// PolicyRef identifies a NetworkPolicy and, for generated policies, the object it was generated for
+type PolicyRef struct {
+	Namespace string
+	Name      string
+	// Owner is the kind/name of the controller owner of the policy, empty for policies not generated by the operator
+	Owner string
+}
+
+func (p PolicyRef) String() string {
+	if p.Owner == "" {
+		return p.Namespace + "/" + p.Name
+	}
+	return fmt.Sprintf("%s/%s (generated for %s)", p.Namespace, p.Name, p.Owner)
+}
+
+// Direction is the verdict of the policies applying to one end of a connection
+type Direction struct {
+	// Isolated is set when at least one policy selects the pod for this direction
+	Isolated bool
+	// Allowed is set when the pod is not isolated or a rule of an isolating policy matches the connection
+	Allowed bool
+	// Isolating lists the policies selecting the pod for this direction
+	Isolating []PolicyRef
+	// Allowing lists the isolating policies with a rule matching the connection
+	Allowing []PolicyRef
+}
+
+// Verdict tells whether a connection is allowed, by the egress policies of the source and the ingress policies
+// of the destination
+type Verdict struct {
+	Source      *corev1.Pod
+	Destination *corev1.Pod
+	Port        int32
+	Protocol    corev1.Protocol
+	Allowed     bool
+	Egress      Direction
+	Ingress     Direction
+}
+
+// Explain describes which policies allow or deny the connection
+func (v Verdict) Explain() string {
+	lines := []string{}
+	result := "DENIED"
+	if v.Allowed {
+		result = "ALLOWED"
+	}
+	lines = append(lines, fmt.Sprintf("%s: %s/%s -> %s/%s %d/%s", result, v.Source.GetNamespace(), v.Source.GetName(), v.Destination.GetNamespace(), v.Destination.GetName(), v.Port, v.Protocol))
+	lines = append(lines, explainDirection("egress of "+v.Source.GetNamespace()+"/"+v.Source.GetName(), v.Egress)...)
+	lines = append(lines, explainDirection("ingress of "+v.Destination.GetNamespace()+"/"+v.Destination.GetName(), v.Ingress)...)
+	return strings.Join(lines, "\n")
+}
+
+func explainDirection(name string, d Direction) []string {
+	if !d.Isolated {
+		return []string{fmt.Sprintf("  %s: allowed, no policy selects the pod", name)}
+	}
+	lines := []string{}
+	if d.Allowed {
+		lines = append(lines, fmt.Sprintf("  %s: allowed by", name))
+		for _, policy := range d.Allowing {
+			lines = append(lines, "    "+policy.String())
+		}
+		return lines
+	}
+	lines = append(lines, fmt.Sprintf("  %s: denied, no rule matches in", name))
+	for _, policy := range d.Isolating {
+		lines = append(lines, "    "+policy.String())
+	}
+	return lines
+}
+
+// Check computes whether source can open a connection to destination on port and protocol
+func (s *Snapshot) Check(source *corev1.Pod, destination *corev1.Pod, port int32, protocol corev1.Protocol) Verdict {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	verdict := Verdict{
+		Source:      source,
+		Destination: destination,
+		Port:        port,
+		Protocol:    protocol,
+		Egress:      Direction{Allowed: true},
+		Ingress:     Direction{Allowed: true},
+	}
+
+	for i := range s.NetworkPolicies {
+		networkPolicy := &s.NetworkPolicies[i]
+		ingress, egress := policyTypes(networkPolicy)
+
+		if egress && s.selects(networkPolicy, source) {
+			verdict.Egress.isolate(networkPolicy)
+			for _, rule := range networkPolicy.Spec.Egress {
+				if s.peersMatch(networkPolicy, rule.To, destination) && portsMatch(rule.Ports, destination, port, protocol) {
+					verdict.Egress.allow(networkPolicy)
+					break
+				}
+			}
+		}
+		if ingress && s.selects(networkPolicy, destination) {
+			verdict.Ingress.isolate(networkPolicy)
+			for _, rule := range networkPolicy.Spec.Ingress {
+				if s.peersMatch(networkPolicy, rule.From, source) && portsMatch(rule.Ports, destination, port, protocol) {
+					verdict.Ingress.allow(networkPolicy)
+					break
+				}
+			}
+		}
+	}
+
+	verdict.Allowed = verdict.Egress.Allowed && verdict.Ingress.Allowed
+	return verdict
+}
+
+func (d *Direction) isolate(networkPolicy *networking.NetworkPolicy) {
+	if !d.Isolated {
+		d.Allowed = false
+	}
+	d.Isolated = true
+	d.Isolating = append(d.Isolating, getPolicyRef(networkPolicy))
+}
+
+func (d *Direction) allow(networkPolicy *networking.NetworkPolicy) {
+	d.Allowed = true
+	d.Allowing = append(d.Allowing, getPolicyRef(networkPolicy))
+}
+
+// Target is a pod and port a service port leads to
+type Target struct {
+	Pod      *corev1.Pod
+	Port     int32
+	Protocol corev1.Protocol
+}
+
+// ServiceTargets returns the pods backing service port, with the target port resolved on each pod
+func (s *Snapshot) ServiceTargets(service *corev1.Service, port int32) []Target {
+	targets := []Target{}
+	if len(service.Spec.Selector) == 0 {
+		return targets
+	}
+	selector := labels.SelectorFromSet(service.Spec.Selector)
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Port != port {
+			continue
+		}
+		for i := range s.Pods {
+			pod := &s.Pods[i]
+			if pod.GetNamespace() != service.GetNamespace() || !selector.Matches(labels.Set(pod.GetLabels())) {
+				continue
+			}
+			protocol := servicePort.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+			targetPort := resolvePort(servicePort.TargetPort, pod, protocol)
+			if servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntVal == 0 {
+				// an unset target port defaults to the service port
+				targetPort = servicePort.Port
+			}
+			if targetPort == 0 {
+				continue
+			}
+			targets = append(targets, Target{Pod: pod, Port: targetPort, Protocol: protocol})
+		}
+	}
+	return targets
+}
+
+// selects reports whether networkPolicy applies to pod
+func (s *Snapshot) selects(networkPolicy *networking.NetworkPolicy, pod *corev1.Pod) bool {
+	if networkPolicy.GetNamespace() != pod.GetNamespace() {
+		return false
+	}
+	return selectorMatches(&networkPolicy.Spec.PodSelector, pod.GetLabels())
+}
+
+// peersMatch reports whether pod is one of peers of a rule of networkPolicy, no peers matching every pod
+func (s *Snapshot) peersMatch(networkPolicy *networking.NetworkPolicy, peers []networking.NetworkPolicyPeer, pod *corev1.Pod) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if peer.IPBlock != nil {
+			if ipBlockMatches(peer.IPBlock, pod.Status.PodIP) {
+				return true
+			}
+			continue
+		}
+		if peer.NamespaceSelector == nil {
+			if pod.GetNamespace() != networkPolicy.GetNamespace() {
+				continue
+			}
+		} else if !selectorMatches(peer.NamespaceSelector, s.namespaceLabels(pod.GetNamespace())) {
+			continue
+		}
+		if peer.PodSelector == nil || selectorMatches(peer.PodSelector, pod.GetLabels()) {
+			return true
+		}
+	}
+	return false
+}
+
+// portsMatch reports whether port and protocol of destination are among ports, no ports matching every port
+func portsMatch(ports []networking.NetworkPolicyPort, destination *corev1.Pod, port int32, protocol corev1.Protocol) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, policyPort := range ports {
+		policyProtocol := corev1.ProtocolTCP
+		if policyPort.Protocol != nil && *policyPort.Protocol != "" {
+			policyProtocol = *policyPort.Protocol
+		}
+		if policyProtocol != protocol {
+			continue
+		}
+		if policyPort.Port == nil || resolvePort(*policyPort.Port, destination, protocol) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePort returns the number of port, named ports being looked up in the containers of pod, 0 if not found
+func resolvePort(port intstr.IntOrString, pod *corev1.Pod, protocol corev1.Protocol) int32 {
+	if port.Type == intstr.Int {
+		return port.IntVal
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			containerProtocol := containerPort.Protocol
+			if containerProtocol == "" {
+				containerProtocol = corev1.ProtocolTCP
+			}
+			if containerPort.Name == port.StrVal && containerProtocol == protocol {
+				return containerPort.ContainerPort
+			}
+		}
+	}
+	return 0
+}
+
+func ipBlockMatches(ipBlock *networking.IPBlock, ip string) bool {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+	_, cidr, err := net.ParseCIDR(ipBlock.CIDR)
+	if err != nil || !cidr.Contains(address) {
+		return false
+	}
+	for _, except := range ipBlock.Except {
+		_, exceptCIDR, err := net.ParseCIDR(except)
+		if err == nil && exceptCIDR.Contains(address) {
+			return false
+		}
+	}
+	return true
+}
+
+func selectorMatches(selector *metav1.LabelSelector, podLabels map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(podLabels))
+}
+
+// policyTypes returns the policy types of networkPolicy, defaulted the way the API server does
+func policyTypes(networkPolicy *networking.NetworkPolicy) (bool, bool) {
+	if len(networkPolicy.Spec.PolicyTypes) == 0 {
+		return true, len(networkPolicy.Spec.Egress) > 0
+	}
+	ingress, egress := false, false
+	for _, policyType := range networkPolicy.Spec.PolicyTypes {
+		switch policyType {
+		case networking.PolicyTypeIngress:
+			ingress = true
+		case networking.PolicyTypeEgress:
+			egress = true
+		}
+	}
+	return ingress, egress
+}
+
+func getPolicyRef(networkPolicy *networking.NetworkPolicy) PolicyRef {
+	ref := PolicyRef{
+		Namespace: networkPolicy.GetNamespace(),
+		Name:      networkPolicy.GetName(),
+	}
+	if owner := metav1.GetControllerOf(networkPolicy); owner != nil {
+		ref.Owner = owner.Kind + "/" + owner.Name
+	}
+	return ref
+}
@@ -0,0 +1,84 @@
+package reachability
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// namespaceNameLabel is set by the API server on every namespace, it is assumed on namespaces of a snapshot
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// Snapshot is the cluster state reachability is computed from
+type Snapshot struct {
+	Namespaces      []corev1.Namespace
+	Pods            []corev1.Pod
+	Services        []corev1.Service
+	NetworkPolicies []networking.NetworkPolicy
+}
+
+// FromCluster reads a snapshot of all namespaces with c
+func FromCluster(c client.Reader) (*Snapshot, error) {
+	namespaces := &corev1.NamespaceList{}
+	err := c.List(context.TODO(), &client.ListOptions{}, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	err = c.List(context.TODO(), &client.ListOptions{}, pods)
+	if err != nil {
+		return nil, err
+	}
+	services := &corev1.ServiceList{}
+	err = c.List(context.TODO(), &client.ListOptions{}, services)
+	if err != nil {
+		return nil, err
+	}
+	networkPolicies := &networking.NetworkPolicyList{}
+	err = c.List(context.TODO(), &client.ListOptions{}, networkPolicies)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Namespaces:      namespaces.Items,
+		Pods:            pods.Items,
+		Services:        services.Items,
+		NetworkPolicies: networkPolicies.Items,
+	}, nil
+}
+
+// FindPod returns the pod called name in namespace, nil if there is none
+func (s *Snapshot) FindPod(namespace string, name string) *corev1.Pod {
+	for i := range s.Pods {
+		if s.Pods[i].GetNamespace() == namespace && s.Pods[i].GetName() == name {
+			return &s.Pods[i]
+		}
+	}
+	return nil
+}
+
+// FindService returns the service called name in namespace, nil if there is none
+func (s *Snapshot) FindService(namespace string, name string) *corev1.Service {
+	for i := range s.Services {
+		if s.Services[i].GetNamespace() == namespace && s.Services[i].GetName() == name {
+			return &s.Services[i]
+		}
+	}
+	return nil
+}
+
+// namespaceLabels returns the labels of the namespace called name, including the name label
+func (s *Snapshot) namespaceLabels(name string) map[string]string {
+	labels := map[string]string{}
+	for _, namespace := range s.Namespaces {
+		if namespace.GetName() == name {
+			for key, value := range namespace.GetLabels() {
+				labels[key] = value
+			}
+		}
+	}
+	labels[namespaceNameLabel] = name
+	return labels
+}
@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// Resolver resolves external hostnames to IP addresses
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// NewResolver returns a Resolver backed by the system DNS configuration
+func NewResolver() Resolver {
+	return &netResolver{
+		resolver: net.DefaultResolver,
+	}
+}
+
+type netResolver struct {
+	resolver *net.Resolver
+}
+
+func (r *netResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := r.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := []net.IP{}
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// StubResolver answers lookups from a static table, it is meant for tests and offline use
+type StubResolver struct {
+	mutex   sync.RWMutex
+	answers map[string][]net.IP
+}
+
+// NewStubResolver returns a StubResolver answering with the given addresses per hostname
+func NewStubResolver(answers map[string][]string) *StubResolver {
+	r := &StubResolver{
+		answers: map[string][]net.IP{},
+	}
+	for host, addresses := range answers {
+		r.Set(host, addresses...)
+	}
+	return r
+}
+
+// Set replaces the answer for host
+func (r *StubResolver) Set(host string, addresses ...string) {
+	ips := []net.IP{}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.answers[host] = ips
+}
+
+func (r *StubResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ips, ok := r.answers[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return append([]net.IP{}, ips...), nil
+}
+
+// CIDRs returns the sorted, de-duplicated host CIDRs of ips
+func CIDRs(ips []net.IP) []string {
+	seen := map[string]bool{}
+	cidrs := []string{}
+	for _, ip := range ips {
+		cidr := fmt.Sprintf("%s/32", ip.String())
+		if ip.To4() == nil {
+			cidr = fmt.Sprintf("%s/128", ip.String())
+		}
+		if !seen[cidr] {
+			seen[cidr] = true
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	sort.Strings(cidrs)
+	return cidrs
+}
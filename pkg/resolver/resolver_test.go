@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCIDRs(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []string
+		want      []string
+	}{
+		{"no address", []string{}, []string{}},
+		{"IPv4", []string{"192.0.2.10"}, []string{"192.0.2.10/32"}},
+		{"IPv6", []string{"2001:db8::1"}, []string{"2001:db8::1/128"}},
+		{"IPv4 mapped IPv6", []string{"::ffff:192.0.2.10"}, []string{"192.0.2.10/32"}},
+		{"sorted", []string{"198.51.100.1", "192.0.2.10"}, []string{"192.0.2.10/32", "198.51.100.1/32"}},
+		{"duplicates", []string{"192.0.2.10", "192.0.2.10"}, []string{"192.0.2.10/32"}},
+	}
+	for _, test := range tests {
+		ips := []net.IP{}
+		for _, address := range test.addresses {
+			ips = append(ips, net.ParseIP(address))
+		}
+		if got := CIDRs(ips); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: CIDRs(%v) = %v, want %v", test.name, test.addresses, got, test.want)
+		}
+	}
+}
+
+func TestStubResolver(t *testing.T) {
+	r := NewStubResolver(map[string][]string{"api.example.com": {"192.0.2.10", "not an address"}})
+
+	ips, err := r.LookupIP(context.TODO(), "api.example.com")
+	if err != nil {
+		t.Fatalf("LookupIP: %v", err)
+	}
+	if got := CIDRs(ips); !reflect.DeepEqual(got, []string{"192.0.2.10/32"}) {
+		t.Errorf("LookupIP answered %v", got)
+	}
+
+	r.Set("api.example.com", "198.51.100.1")
+	ips, err = r.LookupIP(context.TODO(), "api.example.com")
+	if err != nil {
+		t.Fatalf("LookupIP after Set: %v", err)
+	}
+	if got := CIDRs(ips); !reflect.DeepEqual(got, []string{"198.51.100.1/32"}) {
+		t.Errorf("LookupIP after Set answered %v", got)
+	}
+
+	_, err = r.LookupIP(context.TODO(), "unknown.example.com")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("LookupIP of an unknown host returned %v, want a not found DNSError", err)
+	}
+}
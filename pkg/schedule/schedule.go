@@ -0,0 +1,173 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maximum number of years searched for the next activation, expressions such as 0 0 30 2 * never match
+const searchYears = 5
+
+// Schedule is a parsed standard cron expression: minute hour day-of-month month day-of-week
+type Schedule struct {
+	minute     map[int]bool
+	hour       map[int]bool
+	dayOfMonth map[int]bool
+	month      map[int]bool
+	dayOfWeek  map[int]bool
+	// day of month and day of week match if either does when both are restricted
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a 5 field cron expression. Each field supports *, single values, ranges (a-b), lists (a,b) and steps (*/n, a-b/n).
+func Parse(expression string) (*Schedule, error) {
+	parts := strings.Fields(expression)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields, found %d", expression, len(fields), len(parts))
+	}
+	values := []map[int]bool{}
+	for i, part := range parts {
+		value, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %s", expression, err.Error())
+		}
+		values = append(values, value)
+	}
+	// sunday is both 0 and 7
+	if values[4][7] {
+		values[4][0] = true
+		delete(values[4], 7)
+	}
+	return &Schedule{
+		minute:        values[0],
+		hour:          values[1],
+		dayOfMonth:    values[2],
+		month:         values[3],
+		dayOfWeek:     values[4],
+		anyDayOfMonth: strings.HasPrefix(parts[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseField(value string, f field) (map[int]bool, error) {
+	matches := map[int]bool{}
+	for _, item := range strings.Split(value, ",") {
+		step := 1
+		if index := strings.Index(item, "/"); index >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[index+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q in %s field", item[index+1:], f.name)
+			}
+			item = item[:index]
+		}
+		low, high := f.min, f.max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], f); err != nil {
+				return nil, err
+			}
+			if high, err = parseValue(bounds[1], f); err != nil {
+				return nil, err
+			}
+			if low > high {
+				return nil, fmt.Errorf("invalid range %q in %s field", item, f.name)
+			}
+		default:
+			var err error
+			if low, err = parseValue(item, f); err != nil {
+				return nil, err
+			}
+			// a single value with a step starts a series, a single value without one matches only itself
+			if step == 1 {
+				high = low
+			}
+		}
+		for i := low; i <= high; i += step {
+			matches[i] = true
+		}
+	}
+	return matches, nil
+}
+
+func parseValue(value string, f field) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil || i < f.min || i > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, must be between %d and %d", value, f.name, f.min, f.max)
+	}
+	return i, nil
+}
+
+// Next returns the first activation strictly after t, or the zero time if there is none within five years
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(searchYears, 0, 0)
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.dayOfMonth[t.Day()]
+	dayOfWeek := s.dayOfWeek[int(t.Weekday())]
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Window reports whether t falls in a window opened by an activation of s and lasting duration, and when that
+// changes: the end of the current window when open, the next activation when closed. Overlapping windows are merged.
+// The transition is the zero time if the schedule never activates again.
+func (s *Schedule) Window(t time.Time, duration time.Duration) (bool, time.Time) {
+	start := s.Next(t.Add(-duration))
+	if start.IsZero() || start.After(t) {
+		return false, start
+	}
+	end := start.Add(duration)
+	for next := s.Next(start); !next.IsZero() && !next.After(end); next = s.Next(next) {
+		end = next.Add(duration)
+		// an always open window, such as every minute for an hour, is reported open for a day at a time
+		if end.Sub(t) > time.Hour*24 {
+			break
+		}
+	}
+	return true, end
+}
@@ -0,0 +1,143 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func date(value string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"0 9 * *",
+		"0 9 * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"17-9 * * * *",
+		"a * * * *",
+		"1- * * * *",
+	}
+	for _, expression := range tests {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expression)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		from       string
+		want       string
+	}{
+		{"later the same day", "30 2 * * *", "2019-06-01 01:00", "2019-06-01 02:30"},
+		{"strictly after", "30 2 * * *", "2019-06-01 02:30", "2019-06-02 02:30"},
+		{"seconds ignored", "30 2 * * *", "2019-06-01 02:29", "2019-06-01 02:30"},
+		{"list", "0 6,18 * * *", "2019-06-01 07:00", "2019-06-01 18:00"},
+		{"ranges", "0 9-17 * * 1-5", "2019-05-31 18:00", "2019-06-03 09:00"},
+		{"range end", "0 9-17 * * 1-5", "2019-05-31 16:59", "2019-05-31 17:00"},
+		{"step", "*/15 * * * *", "2019-06-01 10:07", "2019-06-01 10:15"},
+		{"step over a range", "10-30/10 * * * *", "2019-06-01 10:31", "2019-06-01 11:10"},
+		{"step from a value", "5/20 * * * *", "2019-06-01 10:26", "2019-06-01 10:45"},
+		{"step wrapping the hour", "5/20 * * * *", "2019-06-01 10:46", "2019-06-01 11:05"},
+		{"sunday as 0", "0 12 * * 0", "2019-05-31 13:00", "2019-06-02 12:00"},
+		{"sunday as 7", "0 12 * * 7", "2019-05-31 13:00", "2019-06-02 12:00"},
+		{"range to sunday as 7", "0 12 * * 5-7", "2019-06-01 13:00", "2019-06-02 12:00"},
+		{"day of month only", "0 0 13 * *", "2019-06-01 00:00", "2019-06-13 00:00"},
+		{"day of month or day of week, week first", "0 0 13 * 5", "2019-06-01 00:00", "2019-06-07 00:00"},
+		{"day of month or day of week, month first", "0 0 13 * 5", "2019-06-08 00:00", "2019-06-13 00:00"},
+		{"starred day of month and day of week", "0 0 */2 * 1", "2019-06-01 00:00", "2019-06-03 00:00"},
+		{"starred day of month skips even days", "0 0 */2 * 1", "2019-06-04 00:00", "2019-06-17 00:00"},
+		{"next year", "0 0 1 1 *", "2019-06-01 00:00", "2020-01-01 00:00"},
+		{"leap day", "0 0 29 2 *", "2019-03-01 00:00", "2020-02-29 00:00"},
+		{"end of month", "59 23 31 * *", "2019-06-01 00:00", "2019-07-31 23:59"},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("%s: Parse(%q): %v", test.name, test.expression, err)
+			continue
+		}
+		if got := s.Next(date(test.from)); !got.Equal(date(test.want)) {
+			t.Errorf("%s: Next(%s) of %q = %s, want %s", test.name, test.from, test.expression, got.Format("2006-01-02 15:04"), test.want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(date("2019-06-01 00:00")); !got.IsZero() {
+		t.Errorf("Next of February 30th = %s, want the zero time", got)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		duration   time.Duration
+		at         string
+		open       bool
+		transition string
+	}{
+		{"before the window", "0 9 * * *", time.Hour, "2019-06-01 08:00", false, "2019-06-01 09:00"},
+		{"window start", "0 9 * * *", time.Hour, "2019-06-01 09:00", true, "2019-06-01 10:00"},
+		{"in the window", "0 9 * * *", time.Hour, "2019-06-01 09:30", true, "2019-06-01 10:00"},
+		{"window end", "0 9 * * *", time.Hour, "2019-06-01 10:00", false, "2019-06-02 09:00"},
+		{"across midnight", "0 23 * * *", 2 * time.Hour, "2019-06-01 00:30", true, "2019-06-01 01:00"},
+		{"overlapping windows", "0 22,23 * * *", 2 * time.Hour, "2019-06-01 22:30", true, "2019-06-02 01:00"},
+		{"overlapping windows after midnight", "0 22,23 * * *", 2 * time.Hour, "2019-06-02 00:30", true, "2019-06-02 01:00"},
+		{"adjacent windows", "0 8,9 * * *", time.Hour, "2019-06-01 08:30", true, "2019-06-01 10:00"},
+		{"between windows", "0 8,12 * * *", time.Hour, "2019-06-01 10:00", false, "2019-06-01 12:00"},
+		{"weekend closed", "0 9 * * 1-5", 8 * time.Hour, "2019-06-01 10:00", false, "2019-06-03 09:00"},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("%s: Parse(%q): %v", test.name, test.expression, err)
+			continue
+		}
+		open, transition := s.Window(date(test.at), test.duration)
+		if open != test.open || !transition.Equal(date(test.transition)) {
+			t.Errorf("%s: Window(%s, %s) of %q = %t, %s, want %t, %s", test.name, test.at, test.duration, test.expression, open, transition.Format("2006-01-02 15:04"), test.open, test.transition)
+		}
+	}
+}
+
+func TestWindowAlwaysOpen(t *testing.T) {
+	s, err := Parse("* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := date("2019-06-01 12:00")
+	open, transition := s.Window(at, time.Hour)
+	if !open || transition.Before(at.Add(24*time.Hour)) || transition.After(at.Add(26*time.Hour)) {
+		t.Errorf("Window of an always open schedule = %t, %s, want open for about a day", open, transition)
+	}
+}
+
+func TestWindowNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open, transition := s.Window(date("2019-06-01 00:00"), time.Hour); open || !transition.IsZero() {
+		t.Errorf("Window of February 30th = %t, %s, want closed for good", open, transition)
+	}
+}